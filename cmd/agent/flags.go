@@ -1,9 +1,13 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/caarlos0/env/v6"
 )
@@ -14,6 +18,20 @@ type Config struct {
 	PollInterval   int    `env:"POLL_INTERVAL"`
 	Key            string `env:"KEY"`
 	RateLimit      int    `env:"RATE_LIMIT"`
+	Labels         string `env:"LABELS"`
+	LatencyBuckets string `env:"LATENCY_BUCKETS"`
+	QueueSize      int    `env:"QUEUE_SIZE"`
+	SpoolPath      string `env:"SPOOL_PATH"`
+	TLSCA          string `env:"TLS_CA"`
+	TLSCert        string `env:"TLS_CERT"`
+	TLSKey         string `env:"TLS_KEY"`
+	CryptoKey      string `env:"CRYPTO_KEY"`
+	GRPCAddress    string `env:"GRPC_ADDRESS"`
+
+	// разобранные Labels, добавляются ко всем метрикам
+	labels map[string]string
+	// разобранные LatencyBuckets
+	latencyBuckets []float64
 }
 
 func getVars() *Config {
@@ -22,6 +40,15 @@ func getVars() *Config {
 	pollInterval := flag.Int("p", 2, "An interval for collecting metrics")
 	key := flag.String("k", "", "Key for hash func")
 	rateLimit := flag.Int("l", 1, "A limit for concurrent requests")
+	labels := flag.String("labels", "", "Labels attached to every metric, e.g. host=alpha,dc=eu")
+	queueSize := flag.Int("queue-size", 0, "Max number of unsent batches kept by agent; 0 sends metrics one by one in -l workers, >0 sends them in batches through the queue")
+	tlsCA := flag.String("tls-ca", "", "Path to CA certificate of the server, enables HTTPS")
+	tlsCert := flag.String("tls-cert", "", "Path to agent TLS certificate for mutual TLS, enables HTTPS")
+	tlsKey := flag.String("tls-key", "", "Path to agent TLS private key")
+	grpcAddress := flag.String("grpc-address", "", "gRPC address of the server, switches sending to gRPC")
+	cryptoKey := flag.String("crypto-key", "", "Path to server RSA public key, enables request encryption")
+	spoolPath := flag.String("spool", "", "File to keep unsent batches between restarts, used with -queue-size")
+	latencyBuckets := flag.String("latency-buckets", "", "Comma separated bucket bounds of SendLatency histogram in seconds")
 
 	flag.Parse()
 
@@ -45,10 +72,76 @@ func getVars() *Config {
 	if cfg.RateLimit == 0 {
 		cfg.RateLimit = *rateLimit
 	}
+	if cfg.Labels == "" {
+		cfg.Labels = *labels
+	}
+	cfg.labels, error = parseLabels(cfg.Labels)
+	if error != nil {
+		log.Fatal(error)
+	}
+	if _, ok := os.LookupEnv("QUEUE_SIZE"); !ok {
+		cfg.QueueSize = *queueSize
+	}
+	if cfg.SpoolPath == "" {
+		cfg.SpoolPath = *spoolPath
+	}
+	if cfg.TLSCA == "" {
+		cfg.TLSCA = *tlsCA
+	}
+	if cfg.TLSCert == "" {
+		cfg.TLSCert = *tlsCert
+	}
+	if cfg.TLSKey == "" {
+		cfg.TLSKey = *tlsKey
+	}
+	if cfg.GRPCAddress == "" {
+		cfg.GRPCAddress = *grpcAddress
+	}
+	if cfg.CryptoKey == "" {
+		cfg.CryptoKey = *cryptoKey
+	}
+	if cfg.LatencyBuckets == "" {
+		cfg.LatencyBuckets = *latencyBuckets
+	}
+	cfg.latencyBuckets, error = parseBuckets(cfg.LatencyBuckets)
+	if error != nil {
+		log.Fatal(error)
+	}
 	return &cfg
 }
 
+func parseLabels(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, errors.New("bad label " + pair)
+		}
+		labels[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return labels, nil
+}
+
+func parseBuckets(s string) ([]float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var buckets []float64
+	for _, b := range strings.Split(s, ",") {
+		val, err := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, val)
+	}
+	return buckets, nil
+}
+
 func (conf *Config) printConfig() {
-	fmt.Printf("Address: %s; Report Interval: %d; Poll Interval: %d; Key: %s; Rate Limit: %d\n",
-		conf.Address, conf.ReportInterval, conf.PollInterval, conf.Key, conf.RateLimit)
+	fmt.Printf("Address: %s; Report Interval: %d; Poll Interval: %d; Key: %s; Rate Limit: %d; Labels: %s; Latency Buckets: %s; Queue Size: %d; Spool Path: %s; TLS CA: %s; TLS Cert: %s; TLS Key: %s\n",
+		conf.Address, conf.ReportInterval, conf.PollInterval, conf.Key, conf.RateLimit, conf.Labels, conf.LatencyBuckets, conf.QueueSize, conf.SpoolPath,
+		conf.TLSCA, conf.TLSCert, conf.TLSKey)
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/metricspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Таймаут одного вызова gRPC
+const grpcTimeout = 10 * time.Second
+
+// grpcMetadataInterceptor добавляет к вызовам адрес агента и подпись запроса,
+// как заголовки X-Real-IP и HashSHA256 в HTTP.
+func grpcMetadataInterceptor(key string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if realIP != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, metricspb.RealIPKey, realIP)
+		}
+		if key != "" {
+			sign, err := metricspb.Sign(req.(proto.Message), key)
+			if err != nil {
+				return err
+			}
+			ctx = metadata.AppendToOutgoingContext(ctx, metricspb.SignKey, sign)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// newGRPCClient подключается к gRPC-серверу по адресу address, с TLS, если
+// задан tlsClientConfig.
+func newGRPCClient(address, key string) (metricspb.MetricsClient, error) {
+	creds := insecure.NewCredentials()
+	if tlsClientConfig != nil {
+		creds = credentials.NewTLS(tlsClientConfig)
+	}
+	conn, err := grpc.Dial(address,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(grpcMetadataInterceptor(key)))
+	if err != nil {
+		return nil, err
+	}
+	return metricspb.NewMetricsClient(conn), nil
+}
+
+// sendBatchGRPC отправляет пакет вызовом UpdateBatch. Ошибки, означающие, что
+// пакет некорректен или запрещён, возвращаются как errRejected.
+func sendBatchGRPC(client metricspb.MetricsClient, storage *memstorage.MemStorage, metrics []memstorage.Metrics) error {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.UpdateBatch(ctx, &metricspb.UpdateBatchRequest{Metrics: metricspb.FromMetricsSlice(metrics)})
+	code := status.Code(err)
+	// как в observeLatency, учитываются только вызовы, на которые сервер ответил
+	if code != codes.Unavailable && code != codes.DeadlineExceeded && code != codes.Canceled {
+		storage.ObserveHistogram("SendLatency", latencyBuckets, time.Since(start).Seconds())
+	}
+	switch code {
+	case codes.OK:
+		return nil
+	case codes.InvalidArgument, codes.PermissionDenied, codes.Unauthenticated, codes.NotFound, codes.FailedPrecondition:
+		return fmt.Errorf("%w: %s", errRejected, err.Error())
+	default:
+		return err
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/metricspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type stubMetricsServer struct {
+	metricspb.UnimplementedMetricsServer
+	code codes.Code
+	md   metadata.MD
+	req  *metricspb.UpdateBatchRequest
+}
+
+func (s *stubMetricsServer) UpdateBatch(ctx context.Context, req *metricspb.UpdateBatchRequest) (*metricspb.UpdateBatchResponse, error) {
+	s.md, _ = metadata.FromIncomingContext(ctx)
+	s.req = req
+	if s.code != codes.OK {
+		return nil, status.Error(s.code, "stub")
+	}
+	return &metricspb.UpdateBatchResponse{Metrics: req.GetMetrics()}, nil
+}
+
+func Test_sendBatchGRPC(t *testing.T) {
+	const key = "secret"
+	stub := &stubMetricsServer{}
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	metricspb.RegisterMetricsServer(server, stub)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(grpcMetadataInterceptor(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := metricspb.NewMetricsClient(conn)
+	realIP = "10.0.0.7"
+	defer func() { realIP = "" }()
+
+	tests := []struct {
+		name         string
+		code         codes.Code
+		wantErr      bool
+		wantRejected bool
+	}{
+		{name: "Test1", code: codes.OK},
+		{name: "Test2", code: codes.Unavailable, wantErr: true},
+		{name: "Test3", code: codes.InvalidArgument, wantErr: true, wantRejected: true},
+		{name: "Test4", code: codes.PermissionDenied, wantErr: true, wantRejected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub.code = tt.code
+			storage := memstorage.NewMemStorage()
+			err := sendBatchGRPC(client, storage, counterBatch(3))
+			if (err != nil) != tt.wantErr || errors.Is(err, errRejected) != tt.wantRejected {
+				t.Fatalf("sendBatchGRPC() error = %v, wantErr %v, wantRejected %v", err, tt.wantErr, tt.wantRejected)
+			}
+			if got := stub.md.Get(metricspb.RealIPKey); len(got) != 1 || got[0] != realIP {
+				t.Errorf("x-real-ip = %v, want %v", got, realIP)
+			}
+			sign, _ := metricspb.Sign(stub.req, key)
+			if got := stub.md.Get(metricspb.SignKey); len(got) != 1 || got[0] != sign {
+				t.Errorf("hashsha256 = %v, want %v", got, sign)
+			}
+			if stub.req.GetMetrics()[0].GetDelta() != 3 {
+				t.Errorf("sent delta = %v, want 3", stub.req.GetMetrics()[0].GetDelta())
+			}
+		})
+	}
+}
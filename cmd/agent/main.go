@@ -4,8 +4,8 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
+	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,9 +22,73 @@ import (
 
 	"github.com/go-resty/resty/v2"
 	"github.com/kishenkoilya/metricsalerts/internal/addressurl"
+	"github.com/kishenkoilya/metricsalerts/internal/envelope"
+	"github.com/kishenkoilya/metricsalerts/internal/httpclient"
 	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/retry"
+	"github.com/kishenkoilya/metricsalerts/internal/tlsconfig"
 )
 
+// Настройки TLS для запросов к серверу, nil - HTTP без шифрования
+var tlsClientConfig *tls.Config
+
+// Адрес агента для заголовка X-Real-IP, пустой - заголовок не передаётся
+var realIP string
+
+func newClient() *resty.Client {
+	return httpclient.New(tlsClientConfig, realIP)
+}
+
+// Открытый ключ сервера, которым шифруются тела запросов, nil - без шифрования
+var cryptoKey *rsa.PublicKey
+
+// Границы корзин гистограммы SendLatency, в секундах
+var latencyBuckets = memstorage.DefaultBuckets
+
+// observeLatency записывает время выполнения запроса в гистограмму SendLatency
+func observeLatency(storage *memstorage.MemStorage, resp *resty.Response, err error) {
+	if err != nil || resp == nil {
+		return
+	}
+	storage.ObserveHistogram("SendLatency", latencyBuckets, resp.Time().Seconds())
+}
+
+// sendPolicy - повторы одного запроса при сетевых ошибках и ответах 5xx
+var sendPolicy = retry.Policy{
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    time.Second,
+	Multiplier:  2,
+	Jitter:      0.5,
+	Retryable:   retryableSend,
+}
+
+func retryableSend(err error) bool {
+	return !errors.Is(err, errRejected)
+}
+
+// postWithRetry отправляет request по sendPolicy. Тело запроса должно
+// допускать повторное чтение, поэтому его нужно задавать срезом байт.
+func postWithRetry(ctx context.Context, storage *memstorage.MemStorage, request *resty.Request, url string) (*resty.Response, error) {
+	var resp *resty.Response
+	err := sendPolicy.Do(ctx, func() error {
+		var err error
+		resp, err = request.SetContext(ctx).Post(url)
+		observeLatency(storage, resp, err)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode() >= http.StatusInternalServerError {
+			return errors.New("server responded " + resp.Status())
+		}
+		if resp.StatusCode() >= http.StatusBadRequest {
+			return fmt.Errorf("%w: %s", errRejected, resp.Status())
+		}
+		return nil
+	})
+	return resp, err
+}
+
 func updateMetrics(m *runtime.MemStats, metrics []string, storage *memstorage.MemStorage) error {
 	runtime.ReadMemStats(m)
 	for _, metricName := range metrics {
@@ -60,37 +124,45 @@ func updateMetrics(m *runtime.MemStats, metrics []string, storage *memstorage.Me
 	return nil
 }
 
-func SendMetrics(addr *addressurl.AddressURL, storage *memstorage.MemStorage, key string, rateLimit int, json bool) {
-	client := resty.NewWithClient(&http.Client{
-		Transport: &http.Transport{
-			DisableCompression: true,
-		},
-	})
+// SendMetrics отправляет метрики в rateLimit потоков. Счётчики и гистограммы
+// забираются из storage с обнулением, а не доставленные из-за ошибок сети или
+// сервера возвращаются обратно,
+// поэтому перекрывающиеся вызовы не отправляют приращение дважды. Метки можно передать
+// только в JSON, а шифруется только тело запроса, поэтому при наличии меток или
+// ключа шифрования всегда используется JSON API.
+func SendMetrics(ctx context.Context, addr *addressurl.AddressURL, storage *memstorage.MemStorage, key string, rateLimit int, json bool, labels map[string]string) {
+	client := newClient()
+	// гистограммы и метки передаются только в JSON, и только JSON можно зашифровать
+	json = json || len(labels) > 0 || cryptoKey != nil
 	ch := make(chan memstorage.Metrics, rateLimit)
-	go fillMetricsChannel(ch, storage)
+	go fillMetricsChannel(ch, storage, json)
 
 	for i := 0; i < rateLimit; i++ {
 		if json {
-			go metricJSONSender(i, client, addr, ch, key)
+			go metricJSONSender(ctx, i, client, addr, ch, key, labels, storage)
 		} else {
-			go metricSender(i, client, addr, ch)
+			go metricSender(ctx, i, client, addr, ch, storage)
 		}
 	}
 }
 
-func fillMetricsChannel(ch chan memstorage.Metrics, storage *memstorage.MemStorage) {
+func fillMetricsChannel(ch chan memstorage.Metrics, storage *memstorage.MemStorage, histograms bool) {
 	var wg sync.WaitGroup
+	if histograms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name, hist := range storage.TakeHistograms() {
+				ch <- memstorage.Metrics{ID: name, MType: "histogram", Histogram: hist}
+			}
+		}()
+	}
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		counters := storage.GetCounters()
-		for name := range counters {
-			stat, metric := storage.GetMetrics("counter", name)
-			if stat != http.StatusOK {
-				fmt.Println("Failed to get metric " + name)
-				continue
-			}
-			ch <- *metric
+		for name, delta := range storage.TakeCounters() {
+			val := delta
+			ch <- memstorage.Metrics{ID: name, MType: "counter", Delta: &val}
 		}
 	}()
 	wg.Add(1)
@@ -110,8 +182,7 @@ func fillMetricsChannel(ch chan memstorage.Metrics, storage *memstorage.MemStora
 	close(ch)
 }
 
-func metricSender(id int, client *resty.Client, addr *addressurl.AddressURL, ch chan memstorage.Metrics) {
-	cli := client.R()
+func metricSender(ctx context.Context, id int, client *resty.Client, addr *addressurl.AddressURL, ch chan memstorage.Metrics, storage *memstorage.MemStorage) {
 	for metric := range ch {
 		var value string
 		if metric.MType == "counter" {
@@ -119,26 +190,29 @@ func metricSender(id int, client *resty.Client, addr *addressurl.AddressURL, ch
 		} else {
 			value = fmt.Sprint(*metric.Value)
 		}
-		resp, err := cli.Post(addr.AddrCommand("update", metric.MType, metric.ID, value))
+		resp, err := postWithRetry(ctx, storage, client.R(), addr.AddrCommand("update", metric.MType, metric.ID, value))
+		restoreUnsent(storage, []memstorage.Metrics{metric}, err)
 		printResponse(resp, err, "metricSender id: "+fmt.Sprint(id))
 	}
 }
 
-func metricJSONSender(id int, client *resty.Client, addr *addressurl.AddressURL, ch chan memstorage.Metrics, key string) {
+func metricJSONSender(ctx context.Context, id int, client *resty.Client, addr *addressurl.AddressURL, ch chan memstorage.Metrics, key string, labels map[string]string, storage *memstorage.MemStorage) {
 	for metric := range ch {
-		metrics := []memstorage.Metrics{metric}
-		request := makeJSONGZIPRequest(client, metrics, key)
+		metric.Labels = labels
+		request := makeJSONGZIPRequest(client, metric, key)
 
-		resp, err := request.Post(addr.AddrCommand("update", "", "", ""))
+		resp, err := postWithRetry(ctx, storage, request, addr.AddrCommand("update", "", "", ""))
+		restoreUnsent(storage, []memstorage.Metrics{metric}, err)
 		printResponse(resp, err, "metricJSONSender id: "+fmt.Sprint(id))
 	}
 }
 
-func SendAllMetrics(addr *addressurl.AddressURL, storage *memstorage.MemStorage, key string) {
-	counters := storage.GetCounters()
+func SendAllMetrics(ctx context.Context, addr *addressurl.AddressURL, storage *memstorage.MemStorage, key string, labels map[string]string) {
+	counters := storage.TakeCounters()
 	gauges := storage.GetGauges()
+	histograms := storage.TakeHistograms()
 
-	len := len(counters) + len(gauges)
+	len := len(counters) + len(gauges) + len(histograms)
 	if len == 0 {
 		return
 	}
@@ -148,33 +222,40 @@ func SendAllMetrics(addr *addressurl.AddressURL, storage *memstorage.MemStorage,
 	for m, v := range counters {
 		val := v
 		metrics[iter] = memstorage.Metrics{
-			ID:    m,
-			MType: "counter",
-			Value: nil,
-			Delta: &val,
+			ID:     m,
+			MType:  "counter",
+			Value:  nil,
+			Delta:  &val,
+			Labels: labels,
 		}
 		iter++
 	}
 	for m, v := range gauges {
 		val := v
 		metrics[iter] = memstorage.Metrics{
-			ID:    m,
-			MType: "gauge",
-			Value: &val,
-			Delta: nil,
+			ID:     m,
+			MType:  "gauge",
+			Value:  &val,
+			Delta:  nil,
+			Labels: labels,
+		}
+		iter++
+	}
+	for m, v := range histograms {
+		metrics[iter] = memstorage.Metrics{
+			ID:        m,
+			MType:     "histogram",
+			Histogram: v,
+			Labels:    labels,
 		}
 		iter++
 	}
 
-	client := resty.NewWithClient(&http.Client{
-		Transport: &http.Transport{
-			DisableCompression: true,
-		},
-	})
+	client := newClient()
 	request := makeJSONGZIPRequest(client, metrics, key)
 	fmt.Println(request.Header.Get("HashSHA256"))
-	resp, err := request.Post(addr.AddrCommand("updates", "", "", ""))
-	defer resp.RawBody().Close()
+	resp, err := postWithRetry(ctx, storage, request, addr.AddrCommand("updates", "", "", ""))
+	restoreUnsent(storage, metrics, err)
 	printResponse(resp, err, "SendAllMetrics")
 	// fmt.Println("result print")
 	// var result []memstorage.Metrics
@@ -187,52 +268,19 @@ func SendAllMetrics(addr *addressurl.AddressURL, storage *memstorage.MemStorage,
 	// }
 }
 
-func makeJSONGZIPRequest(client *resty.Client, reqBody []memstorage.Metrics, key string) *resty.Request {
-	var jsonData []byte
-	var err error
-	if len(reqBody) != 1 {
-		jsonData, err = json.Marshal(reqBody)
-	} else {
-		jsonData, err = json.Marshal(reqBody[0])
-	}
-	// fmt.Println(string(jsonData))
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
-
-	var buf bytes.Buffer
-	gzipWriter := gzip.NewWriter(&buf)
-	_, err = gzipWriter.Write(jsonData)
+// makeJSONGZIPRequest готовит запрос с телом reqBody: метрикой для /update/
+// или срезом метрик для /updates/.
+func makeJSONGZIPRequest(client *resty.Client, reqBody interface{}, key string) *resty.Request {
+	request, err := httpclient.JSONGZIPRequest(client, reqBody, key, cryptoKey)
 	if err != nil {
 		fmt.Println(err)
 		return nil
 	}
-	gzipWriter.Close()
-	request := client.R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Content-Encoding", "gzip").
-		SetHeader("Accept-Encoding", "gzip").
-		SetBody(&buf)
-	if key != "" {
-		sign := generateHMACSHA256(jsonData, key)
-		request.SetHeader("HashSHA256", string(sign))
-	}
 	return request
 }
 
-func generateHMACSHA256(data []byte, key string) string {
-	h := hmac.New(sha256.New, []byte(key))
-	h.Write(data)
-	return fmt.Sprintf("%x", h.Sum(nil))
-}
-
 func getJSONMetrics(mType, mName string, addr *addressurl.AddressURL, usegzip bool, key string) *resty.Response {
-	client := resty.NewWithClient(&http.Client{
-		Transport: &http.Transport{
-			DisableCompression: true,
-		},
-	})
+	client := newClient()
 	reqBody := memstorage.Metrics{ID: mName, MType: mType}
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
@@ -243,8 +291,7 @@ func getJSONMetrics(mType, mName string, addr *addressurl.AddressURL, usegzip bo
 	request := client.R()
 
 	if key != "" {
-		sign := generateHMACSHA256(jsonData, key)
-		request.SetHeader("HashSHA256", string(sign))
+		request.SetHeader(httpclient.SignHeader, httpclient.Sign(jsonData, key))
 	}
 
 	if usegzip {
@@ -272,7 +319,7 @@ func getJSONMetrics(mType, mName string, addr *addressurl.AddressURL, usegzip bo
 }
 
 func getMetric(mType, mName string, addr *addressurl.AddressURL) *resty.Response {
-	client := resty.New()
+	client := newClient()
 	resp, err := client.R().Get(addr.AddrCommand("value", mType, mName, ""))
 	if err != nil {
 		fmt.Println(err)
@@ -291,11 +338,7 @@ func decompressGzipResponse(data []byte) ([]byte, error) {
 }
 
 func getAllMetrics(addr *addressurl.AddressURL) *resty.Response {
-	client := resty.NewWithClient(&http.Client{
-		Transport: &http.Transport{
-			DisableCompression: true,
-		},
-	})
+	client := newClient()
 	resp, err := client.R().Get(addr.AddrEmpty())
 	printResponse(resp, err, "getAllMetrics")
 	return resp
@@ -335,8 +378,35 @@ func main() {
 	ctx := context.Background()
 
 	config := getVars()
+	if config.latencyBuckets != nil {
+		latencyBuckets = config.latencyBuckets
+	}
 
 	addr := addressurl.AddressURL{Protocol: "http", Address: (*config).Address}
+	if config.TLSCA != "" || config.TLSCert != "" {
+		var err error
+		tlsClientConfig, err = tlsconfig.Client(config.TLSCA, config.TLSCert, config.TLSKey)
+		if err != nil {
+			panic(err)
+		}
+		addr.Protocol = "https"
+	}
+	serverAddress := config.Address
+	if config.GRPCAddress != "" {
+		serverAddress = config.GRPCAddress
+	}
+	if ip, err := httpclient.OutboundIP(serverAddress); err != nil {
+		fmt.Println("outbound address:", err)
+	} else {
+		realIP = ip
+	}
+	if config.CryptoKey != "" {
+		var err error
+		cryptoKey, err = envelope.LoadPublicKey(config.CryptoKey)
+		if err != nil {
+			panic(err)
+		}
+	}
 
 	metrics := []string{"Alloc", "BuckHashSys", "Frees", "GCCPUFraction", "GCSys", "HeapAlloc",
 		"HeapIdle", "HeapInuse", "HeapObjects", "HeapReleased", "HeapSys", "LastGC", "Lookups",
@@ -345,6 +415,33 @@ func main() {
 	storage := memstorage.NewMemStorage()
 	var m runtime.MemStats
 
+	queueSize := config.QueueSize
+	if config.GRPCAddress != "" && queueSize == 0 {
+		// по gRPC метрики отправляются только пакетами через очередь
+		queueSize = 1
+	}
+	var queue *sendQueue
+	if queueSize > 0 {
+		var err error
+		queue, err = newSendQueue(queueSize, config.SpoolPath)
+		if err != nil {
+			panic(err)
+		}
+	}
+	client := newClient()
+	send := func(batch []memstorage.Metrics) error {
+		return sendBatch(client, &addr, config.Key, storage, batch)
+	}
+	if config.GRPCAddress != "" {
+		grpcClient, err := newGRPCClient(config.GRPCAddress, config.Key)
+		if err != nil {
+			panic(err)
+		}
+		send = func(batch []memstorage.Metrics) error {
+			return sendBatchGRPC(grpcClient, storage, batch)
+		}
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
@@ -371,54 +468,29 @@ func main() {
 		defer wg.Done()
 		ticker := time.NewTicker(time.Duration((*config).ReportInterval) * time.Second)
 		defer ticker.Stop()
+		// повторные попытки отправить очередь между отчётами
+		flushTicker := time.NewTicker(time.Second)
+		defer flushTicker.Stop()
 
 		for {
 			select {
+			case <-flushTicker.C:
+				if queue != nil && queue.Len() > 0 {
+					if err := queue.Flush(time.Now(), send); err != nil {
+						fmt.Println("Failed to flush queue: " + err.Error())
+					}
+				}
 			case <-ticker.C:
 				fmt.Println("Sending metrics")
 				// testMass(&addr)
-				SendMetrics(&addr, storage, config.Key, config.RateLimit, false)
-				// SendMetrics(&addr, storage, config.Key, config.RateLimit, true)
-				// SendAllMetrics(&addr, storage, (*config).Key)
-				// client := resty.New().R()
-				// resp, err := client.Get(addr.AddrCommand("ping", "", "", ""))
-				// if err != nil {
-				// 	fmt.Println(err.Error())
-				// }
-				// fmt.Println(client.URL)
-				// fmt.Println(string(resp.Body()))
-				// // fmt.Println(client.RawRequest.URL)
-				// fmt.Println(resp.Proto() + " " + resp.Status())
-				// for k, v := range resp.Header() {
-				// 	fmt.Print(k + ": ")
-				// 	for _, s := range v {
-				// 		fmt.Print(fmt.Sprint(s))
-				// 	}
-				// 	fmt.Print("\n")
-				// }
-				// time.Sleep(1 * time.Second)
-
-				// resp := getAllMetrics(&addr)
-				// fmt.Println(string(resp.Body()))
-				// fmt.Println("all metr")
-				// client := resty.New().R()
-				// client.Header.Set("Accept", "html/text")
-				// client.Header.Set("Accept-Encoding", "gzip")
-				// resp, err := client.Get(addr.AddrEmpty())
-				// if err != nil {
-				// 	fmt.Println(err.Error())
-				// }
-				// fmt.Println(client.URL)
-				// fmt.Println(string(resp.Body()))
-				// // fmt.Println(client.RawRequest.URL)
-				// fmt.Println(resp.Proto() + " " + resp.Status())
-				// for k, v := range resp.Header() {
-				// 	fmt.Print(k + ": ")
-				// 	for _, s := range v {
-				// 		fmt.Print(fmt.Sprint(s))
-				// 	}
-				// 	fmt.Print("\n")
-				// }
+				if queue == nil {
+					SendMetrics(ctx, &addr, storage, config.Key, config.RateLimit, false, config.labels)
+					continue
+				}
+				queue.Push(collectMetrics(storage, config.labels))
+				if err := queue.Flush(time.Now(), send); err != nil {
+					fmt.Println("Failed to send metrics, " + fmt.Sprint(queue.Len()) + " batches queued: " + err.Error())
+				}
 			case <-ctx.Done():
 				return
 			}
@@ -451,11 +523,7 @@ func testMass(addr *addressurl.AddressURL) {
 		{ID: "CounterBatchZip23", MType: "counter", Delta: &delta4},
 		{ID: "GaugeBatchZip142", MType: "gauge", Value: &value4},
 	}
-	client := resty.NewWithClient(&http.Client{
-		Transport: &http.Transport{
-			DisableCompression: true,
-		},
-	})
+	client := newClient()
 	request := makeJSONGZIPRequest(client, metrics, "")
 	resp, err := request.Post(addr.AddrCommand("updates", "", "", ""))
 	defer resp.RawBody().Close()
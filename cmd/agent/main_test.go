@@ -1,10 +1,24 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/kishenkoilya/metricsalerts/internal/addressurl"
+	"github.com/kishenkoilya/metricsalerts/internal/envelope"
 	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
 )
 
@@ -33,3 +47,157 @@ func Test_updateMetrics(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "Test1", s: "", want: nil},
+		{name: "Test2", s: "host=alpha, dc=eu", want: map[string]string{"host": "alpha", "dc": "eu"}},
+		{name: "Test3", s: "host", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLabels(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseLabels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendAllMetrics_counterDelta(t *testing.T) {
+	var total int64
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var metrics []memstorage.Metrics
+		if err = json.NewDecoder(reader).Decode(&metrics); err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range metrics {
+			if m.MType == "counter" {
+				total += *m.Delta
+			}
+		}
+	}))
+	defer srv.Close()
+	addr := &addressurl.AddressURL{Protocol: "http", Address: strings.TrimPrefix(srv.URL, "http://")}
+
+	storage := memstorage.NewMemStorage()
+	storage.PutCounter("PollCount", 3)
+	SendAllMetrics(context.Background(), addr, storage, "", nil)
+	fail = false
+	storage.PutCounter("PollCount", 2)
+	SendAllMetrics(context.Background(), addr, storage, "", nil)
+	storage.PutCounter("PollCount", 1)
+	SendAllMetrics(context.Background(), addr, storage, "", nil)
+
+	// неудачная отправка не теряет приращение, удачная не повторяет его
+	if total != 6 {
+		t.Errorf("server received %d, want 6", total)
+	}
+	if val, _ := storage.GetCounter("PollCount"); val != 0 {
+		t.Errorf("agent counter = %d, want 0", val)
+	}
+}
+
+func TestSendMetrics_counterDelta(t *testing.T) {
+	var total, requests, fail int64 = 0, 0, 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		if atomic.LoadInt64(&fail) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		parts := strings.Split(r.URL.Path, "/")
+		if len(parts) == 5 && parts[2] == "counter" {
+			delta, err := strconv.ParseInt(parts[4], 10, 64)
+			if err != nil {
+				t.Error(err)
+			}
+			atomic.AddInt64(&total, delta)
+		}
+	}))
+	defer srv.Close()
+	addr := &addressurl.AddressURL{Protocol: "http", Address: strings.TrimPrefix(srv.URL, "http://")}
+	ctx := context.Background()
+	storage := memstorage.NewMemStorage()
+	waitFor := func(cond func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// приращение, не доставленное из-за ошибки сервера, возвращается в storage
+	storage.PutCounter("PollCount", 3)
+	SendMetrics(ctx, addr, storage, "", 1, false, nil)
+	waitFor(func() bool {
+		val, _ := storage.GetCounter("PollCount")
+		return atomic.LoadInt64(&requests) == int64(sendPolicy.MaxAttempts) && val == 3
+	})
+	if val, _ := storage.GetCounter("PollCount"); val != 3 {
+		t.Fatalf("agent counter after failed send = %d, want 3", val)
+	}
+
+	// вызовы подряд не дожидаются друг друга, но приращение уходит один раз
+	atomic.StoreInt64(&fail, 0)
+	SendMetrics(ctx, addr, storage, "", 2, false, nil)
+	storage.PutCounter("PollCount", 2)
+	SendMetrics(ctx, addr, storage, "", 2, false, nil)
+	waitFor(func() bool { return atomic.LoadInt64(&total) >= 5 })
+	time.Sleep(100 * time.Millisecond)
+	if got := atomic.LoadInt64(&total); got != 5 {
+		t.Errorf("server received %d, want 5", got)
+	}
+	if val, _ := storage.GetCounter("PollCount"); val != 0 {
+		t.Errorf("agent counter = %d, want 0", val)
+	}
+}
+
+func TestSendMetrics_encrypted(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cryptoKey = &priv.PublicKey
+	defer func() { cryptoKey = nil }()
+
+	var plain, encrypted int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/update/" && r.Header.Get(envelope.Header) == envelope.Scheme {
+			atomic.AddInt64(&encrypted, 1)
+		} else {
+			atomic.AddInt64(&plain, 1)
+		}
+	}))
+	defer srv.Close()
+	addr := &addressurl.AddressURL{Protocol: "http", Address: strings.TrimPrefix(srv.URL, "http://")}
+
+	storage := memstorage.NewMemStorage()
+	storage.PutCounter("PollCount", 1)
+	storage.PutGauge("Alloc", 1.5)
+	// без меток и флага json агент всё равно шифрует запросы через JSON API
+	SendMetrics(context.Background(), addr, storage, "", 1, false, nil)
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&encrypted)+atomic.LoadInt64(&plain) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, gotPlain := atomic.LoadInt64(&encrypted), atomic.LoadInt64(&plain); got != 2 || gotPlain != 0 {
+		t.Errorf("encrypted = %d, plain = %d, want 2 and 0", got, gotPlain)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/kishenkoilya/metricsalerts/internal/addressurl"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/retry"
+)
+
+// errRejected - сервер отклонил пакет, повторная отправка не поможет
+var errRejected = errors.New("batch rejected by server")
+
+// sendQueue - ограниченная очередь неотправленных пакетов метрик.
+// Пакеты отправляются строго по порядку, при ошибке очередь ждёт
+// с экспоненциальной задержкой. Если очередь переполнена, два самых старых
+// пакета объединяются в один, поэтому приращения счётчиков не теряются.
+type sendQueue struct {
+	mu         sync.Mutex
+	batches    [][]memstorage.Metrics
+	maxBatches int
+	// файл, в котором очередь переживает перезапуск агента; пустой - только в памяти
+	spoolPath string
+
+	attempts int
+	nextTry  time.Time
+	policy   retry.Policy
+}
+
+func newSendQueue(maxBatches int, spoolPath string) (*sendQueue, error) {
+	q := &sendQueue{
+		maxBatches: maxBatches,
+		spoolPath:  spoolPath,
+		policy:     retry.Policy{BaseDelay: time.Second, MaxDelay: time.Minute, Multiplier: 2, Jitter: 0.5},
+	}
+	if spoolPath == "" {
+		return q, nil
+	}
+	data, err := os.ReadFile(spoolPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &q.batches); err != nil {
+		return nil, err
+	}
+	q.shrink()
+	return q, nil
+}
+
+func (q *sendQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.batches)
+}
+
+// Push добавляет пакет в конец очереди.
+func (q *sendQueue) Push(batch []memstorage.Metrics) {
+	if len(batch) == 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.batches = append(q.batches, batch)
+	q.shrink()
+	q.save()
+}
+
+func (q *sendQueue) shrink() {
+	for len(q.batches) > q.maxBatches && len(q.batches) > 1 {
+		q.batches[1] = mergeBatches(q.batches[0], q.batches[1])
+		q.batches = q.batches[1:]
+	}
+}
+
+// Flush отправляет пакеты по порядку, пока send не вернёт ошибку.
+// До истечения задержки после предыдущей ошибки ничего не отправляется.
+func (q *sendQueue) Flush(now time.Time, send func([]memstorage.Metrics) error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if now.Before(q.nextTry) {
+		return nil
+	}
+	defer q.save()
+	for len(q.batches) > 0 {
+		err := send(q.batches[0])
+		if err != nil && !errors.Is(err, errRejected) {
+			q.attempts++
+			q.nextTry = now.Add(q.policy.Delay(q.attempts))
+			return err
+		}
+		if err != nil {
+			fmt.Println("Batch rejected, dropping it: " + err.Error())
+		}
+		q.batches = q.batches[1:]
+		q.attempts = 0
+		q.nextTry = time.Time{}
+	}
+	return nil
+}
+
+func (q *sendQueue) save() {
+	if q.spoolPath == "" {
+		return
+	}
+	data, err := json.Marshal(q.batches)
+	if err != nil {
+		fmt.Println("Failed to marshal queue: " + err.Error())
+		return
+	}
+	// пишем во временный файл и переименовываем, чтобы не оставить обрезанный spool
+	tmp := q.spoolPath + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		fmt.Println("Failed to write queue spool: " + err.Error())
+		return
+	}
+	if err = os.Rename(tmp, q.spoolPath); err != nil {
+		fmt.Println("Failed to write queue spool: " + err.Error())
+	}
+}
+
+// mergeBatches объединяет два пакета: приращения счётчиков и гистограммы
+// складываются, для gauge остаётся более новое значение.
+func mergeBatches(older, newer []memstorage.Metrics) []memstorage.Metrics {
+	res := make([]memstorage.Metrics, 0, len(older)+len(newer))
+	index := make(map[string]int)
+	for _, batch := range [][]memstorage.Metrics{older, newer} {
+		for _, metric := range batch {
+			key := metric.MType + " " + memstorage.SeriesKey(metric.ID, metric.Labels)
+			i, ok := index[key]
+			if !ok {
+				index[key] = len(res)
+				res = append(res, copyMetric(metric))
+				continue
+			}
+			cur := &res[i]
+			switch metric.MType {
+			case "counter":
+				*cur.Delta += *metric.Delta
+			case "histogram":
+				if cur.Histogram.Merge(metric.Histogram) != nil {
+					cur.Histogram = metric.Histogram.Copy()
+				}
+			default:
+				*cur = copyMetric(metric)
+			}
+		}
+	}
+	return res
+}
+
+func copyMetric(metric memstorage.Metrics) memstorage.Metrics {
+	if metric.Delta != nil {
+		delta := *metric.Delta
+		metric.Delta = &delta
+	}
+	if metric.Value != nil {
+		value := *metric.Value
+		metric.Value = &value
+	}
+	if metric.Histogram != nil {
+		metric.Histogram = metric.Histogram.Copy()
+	}
+	return metric
+}
+
+// collectMetrics забирает из storage пакет для отправки. Счётчики и гистограммы
+// обнуляются: дальше за их доставку отвечает очередь.
+func collectMetrics(storage *memstorage.MemStorage, labels map[string]string) []memstorage.Metrics {
+	counters := storage.TakeCounters()
+	gauges := storage.GetGauges()
+	histograms := storage.TakeHistograms()
+
+	metrics := make([]memstorage.Metrics, 0, len(counters)+len(gauges)+len(histograms))
+	for m, v := range counters {
+		val := v
+		metrics = append(metrics, memstorage.Metrics{ID: m, MType: "counter", Delta: &val, Labels: labels})
+	}
+	for m, v := range gauges {
+		val := v
+		metrics = append(metrics, memstorage.Metrics{ID: m, MType: "gauge", Value: &val, Labels: labels})
+	}
+	for m, v := range histograms {
+		metrics = append(metrics, memstorage.Metrics{ID: m, MType: "histogram", Histogram: v, Labels: labels})
+	}
+	return metrics
+}
+
+// restoreUnsent возвращает в storage приращения счётчиков и гистограммы из
+// метрик, которые не удалось отправить из-за ошибки err, чтобы они ушли со
+// следующим отчётом. Gauge не возвращаются: в storage уже есть значение новее.
+// Отклонённые сервером метрики (errRejected) отбрасываются: повтор не поможет,
+// а если сервер применил пакет частично, приращения ушли бы дважды.
+func restoreUnsent(storage *memstorage.MemStorage, metrics []memstorage.Metrics, err error) {
+	if err == nil || errors.Is(err, errRejected) {
+		return
+	}
+	for _, metric := range metrics {
+		switch metric.MType {
+		case "counter":
+			storage.PutCounter(metric.ID, *metric.Delta)
+		case "histogram":
+			storage.PutHistogram(metric.ID, metric.Histogram)
+		}
+	}
+}
+
+// sendBatch отправляет пакет на /updates/. Ответ 4xx означает, что пакет
+// некорректен, и возвращается как errRejected.
+func sendBatch(client *resty.Client, addr *addressurl.AddressURL, key string, storage *memstorage.MemStorage, metrics []memstorage.Metrics) error {
+	request := makeJSONGZIPRequest(client, metrics, key)
+	if request == nil {
+		return errRejected
+	}
+	resp, err := request.Post(addr.AddrCommand("updates", "", "", ""))
+	observeLatency(storage, resp, err)
+	printResponse(resp, err, "sendBatch")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() >= http.StatusInternalServerError {
+		return errors.New("server responded " + resp.Status())
+	}
+	if resp.StatusCode() >= http.StatusBadRequest {
+		return fmt.Errorf("%w: %s", errRejected, resp.Status())
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+)
+
+func counterBatch(delta int64) []memstorage.Metrics {
+	return []memstorage.Metrics{{ID: "PollCount", MType: "counter", Delta: &delta}}
+}
+
+func Test_mergeBatches(t *testing.T) {
+	d1, d2, v1, v2 := int64(2), int64(3), 1.5, 2.5
+	older := []memstorage.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &d1},
+		{ID: "Alloc", MType: "gauge", Value: &v1},
+	}
+	newer := []memstorage.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &v2},
+		{ID: "PollCount", MType: "counter", Delta: &d2},
+		{ID: "PollCount", MType: "counter", Delta: &d2, Labels: map[string]string{"host": "a"}},
+	}
+	got := mergeBatches(older, newer)
+	if len(got) != 3 {
+		t.Fatalf("mergeBatches() returned %d metrics, want 3", len(got))
+	}
+	if *got[0].Delta != 5 || *got[1].Value != 2.5 || *got[2].Delta != 3 {
+		t.Errorf("mergeBatches() = %v %v %v", *got[0].Delta, *got[1].Value, *got[2].Delta)
+	}
+	if d1 != 2 {
+		t.Errorf("mergeBatches() modified its input")
+	}
+}
+
+func Test_sendQueue_Flush(t *testing.T) {
+	q, _ := newSendQueue(2, "")
+	q.Push(counterBatch(1))
+	q.Push(counterBatch(2))
+	q.Push(counterBatch(4))
+	if q.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", q.Len())
+	}
+
+	now := time.Now()
+	var sent []int64
+	fail := errors.New("connection refused")
+	send := func(batch []memstorage.Metrics) error {
+		if fail != nil {
+			return fail
+		}
+		sent = append(sent, *batch[0].Delta)
+		return nil
+	}
+	if err := q.Flush(now, send); err == nil {
+		t.Fatal("Flush() should fail while server is down")
+	}
+	fail = nil
+	if err := q.Flush(now, send); err != nil || len(sent) != 0 {
+		t.Fatalf("Flush() before backoff expired sent %v, %v", sent, err)
+	}
+	if err := q.Flush(now.Add(q.policy.MaxDelay), send); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	// первые два пакета объединились при переполнении
+	if len(sent) != 2 || sent[0] != 3 || sent[1] != 4 || q.Len() != 0 {
+		t.Errorf("Flush() sent %v, %d left", sent, q.Len())
+	}
+}
+
+func Test_sendQueue_Reject(t *testing.T) {
+	q, _ := newSendQueue(10, "")
+	value := 1.5
+	q.Push(append(counterBatch(5), memstorage.Metrics{ID: "Alloc", MType: "gauge", Value: &value}))
+	q.Push(counterBatch(3))
+
+	// сервер отклоняет первый пакет, например из-за гистограммы с другими
+	// корзинами; он отбрасывается, а следующий пакет всё равно отправляется
+	var sent []int64
+	err := q.Flush(time.Now(), func(batch []memstorage.Metrics) error {
+		if len(batch) > 1 {
+			return errRejected
+		}
+		sent = append(sent, *batch[0].Delta)
+		return nil
+	})
+	if err != nil || q.Len() != 0 || len(sent) != 1 || sent[0] != 3 {
+		t.Fatalf("Flush() = %v, sent %v, %d left, want rejected batch dropped", err, sent, q.Len())
+	}
+}
+
+func Test_restoreUnsent(t *testing.T) {
+	value := 1.5
+	tests := []struct {
+		name string
+		err  error
+		want int64
+	}{
+		{name: "Test1", err: nil, want: 2},
+		{name: "Test2", err: errors.New("connection refused"), want: 7},
+		// отклонённое приращение не возвращается, иначе ушло бы повторно
+		{name: "Test3", err: fmt.Errorf("%w: 400 Bad Request", errRejected), want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := memstorage.NewMemStorage()
+			storage.PutCounter("PollCount", 2)
+			restoreUnsent(storage, append(counterBatch(5), memstorage.Metrics{ID: "Alloc", MType: "gauge", Value: &value}), tt.err)
+			if got, _ := storage.GetCounter("PollCount"); got != tt.want {
+				t.Errorf("PollCount = %v, want %v", got, tt.want)
+			}
+			if _, ok := storage.GetGauge("Alloc"); ok {
+				t.Error("gauge should not be restored")
+			}
+		})
+	}
+}
+
+func Test_sendQueue_Spool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	q, err := newSendQueue(10, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Push(counterBatch(7))
+
+	q, err = newSendQueue(10, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Len() != 1 {
+		t.Fatalf("Len() after reload = %d, want 1", q.Len())
+	}
+	q.Flush(time.Now(), func(batch []memstorage.Metrics) error {
+		if *batch[0].Delta != 7 {
+			t.Errorf("reloaded batch delta = %d, want 7", *batch[0].Delta)
+		}
+		return nil
+	})
+	q, _ = newSendQueue(10, path)
+	if q.Len() != 0 {
+		t.Errorf("Len() after flush and reload = %d, want 0", q.Len())
+	}
+}
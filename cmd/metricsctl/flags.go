@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/caarlos0/env/v6"
+)
+
+type Config struct {
+	Address   string `env:"ADDRESS"`
+	Key       string `env:"KEY"`
+	TLSCA     string `env:"TLS_CA"`
+	TLSCert   string `env:"TLS_CERT"`
+	TLSKey    string `env:"TLS_KEY"`
+	CryptoKey string `env:"CRYPTO_KEY"`
+	// формат вывода: table, json или csv, пустой - формат команды по умолчанию
+	Output string
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: metricsctl [flags] <command> [args]
+
+Commands:
+  get <type> <name> [label=value...]          print a metric
+  set <type> <name> <value> [label=value...]  update a metric, counters are incremented by value
+  list [-type t] [-prefix p] [-match re] [-label k=v]
+                                              list metrics
+  watch [-type t] [-prefix p]                 print metric changes until interrupted
+  push-file <file|->                          send a JSON array of metrics, e.g. made by export
+  export                                      print all metrics, JSON by default
+  ping                                        check the server and its storage
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+func getVars() *Config {
+	address := flag.String("a", "localhost:8080", "An address of the server")
+	key := flag.String("k", "", "Key for hash func")
+	output := flag.String("o", "", "Output format: table, json or csv")
+	tlsCA := flag.String("tls-ca", "", "Path to CA certificate of the server, enables HTTPS")
+	tlsCert := flag.String("tls-cert", "", "Path to client TLS certificate for mutual TLS, enables HTTPS")
+	tlsKey := flag.String("tls-key", "", "Path to client TLS private key")
+	cryptoKey := flag.String("crypto-key", "", "Path to server RSA public key, enables encryption of updates")
+	flag.Usage = usage
+
+	flag.Parse()
+
+	var cfg Config
+	error := env.Parse(&cfg)
+	if error != nil {
+		log.Fatal(error)
+	}
+	if cfg.Address == "" {
+		cfg.Address = *address
+	}
+	if cfg.Key == "" {
+		cfg.Key = *key
+	}
+	if cfg.TLSCA == "" {
+		cfg.TLSCA = *tlsCA
+	}
+	if cfg.TLSCert == "" {
+		cfg.TLSCert = *tlsCert
+	}
+	if cfg.TLSKey == "" {
+		cfg.TLSKey = *tlsKey
+	}
+	if cfg.CryptoKey == "" {
+		cfg.CryptoKey = *cryptoKey
+	}
+	cfg.Output = *output
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	return &cfg
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/kishenkoilya/metricsalerts/internal/addressurl"
+	"github.com/kishenkoilya/metricsalerts/internal/api"
+	"github.com/kishenkoilya/metricsalerts/internal/envelope"
+	"github.com/kishenkoilya/metricsalerts/internal/httpclient"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/tlsconfig"
+)
+
+const (
+	// размер страницы /api/metrics и пачки push-file
+	pageSize  = 1000
+	pushBatch = 1000
+)
+
+var errUsage = errors.New("bad arguments")
+
+// ctl - общие для всех команд настройки.
+type ctl struct {
+	addr   addressurl.AddressURL
+	client *resty.Client
+	key    string
+	// открытый ключ сервера для шифрования записей, nil - без шифрования
+	pub    *rsa.PublicKey
+	output string
+	out    io.Writer
+	// откуда push-file читает "-"
+	in io.Reader
+}
+
+func (c *ctl) format(def string) string {
+	if c.output != "" {
+		return c.output
+	}
+	return def
+}
+
+func (c *ctl) run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch c.output {
+	case "", "table", "json", "csv":
+	default:
+		return fmt.Errorf("%w: %s", errUsage, errFormat)
+	}
+	switch args[0] {
+	case "get":
+		return c.get(ctx, args[1:])
+	case "set":
+		return c.set(ctx, args[1:])
+	case "list":
+		return c.list(ctx, args[1:])
+	case "watch":
+		return c.watch(ctx, args[1:])
+	case "push-file":
+		return c.pushFile(ctx, args[1:])
+	case "export":
+		return c.export(ctx, args[1:])
+	case "ping":
+		return c.ping(ctx, args[1:])
+	}
+	return fmt.Errorf("%w: unknown command %s", errUsage, args[0])
+}
+
+func checkResponse(resp *resty.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	if !resp.IsSuccess() {
+		return fmt.Errorf("server responded %s: %s", resp.Status(), strings.TrimSpace(string(resp.Body())))
+	}
+	return nil
+}
+
+// parseLabelArgs разбирает метки вида key=value.
+func parseLabelArgs(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, arg := range args {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("%w: bad label %s", errUsage, arg)
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+// labelFlags - повторяющийся флаг -label key=value.
+type labelFlags []string
+
+func (l *labelFlags) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *labelFlags) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// post отправляет body как JSON с подписью и возвращает ответ сервера.
+// Шифруются только записи: /value/ принимает тело как есть.
+func (c *ctl) post(ctx context.Context, command string, body interface{}) (*resty.Response, error) {
+	pub := c.pub
+	if command == "value" {
+		pub = nil
+	}
+	request, err := httpclient.JSONGZIPRequest(c.client, body, c.key, pub)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := request.SetContext(ctx).Post(c.addr.AddrCommand(command, "", "", ""))
+	return resp, checkResponse(resp, err)
+}
+
+func (c *ctl) get(ctx context.Context, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	labels, err := parseLabelArgs(args[2:])
+	if err != nil {
+		return err
+	}
+	resp, err := c.post(ctx, "value", memstorage.Metrics{ID: args[1], MType: args[0], Labels: labels})
+	if err != nil {
+		return err
+	}
+	var metric memstorage.Metrics
+	if err := json.Unmarshal(resp.Body(), &metric); err != nil {
+		return err
+	}
+	return writeMetric(c.out, c.format("table"), &metric)
+}
+
+func (c *ctl) set(ctx context.Context, args []string) error {
+	if len(args) < 3 {
+		return errUsage
+	}
+	labels, err := parseLabelArgs(args[3:])
+	if err != nil {
+		return err
+	}
+	metric := memstorage.Metrics{ID: args[1], MType: args[0], Labels: labels}
+	switch metric.MType {
+	case "counter":
+		delta, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: counter value must be an integer", errUsage)
+		}
+		metric.Delta = &delta
+	case "gauge":
+		value, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return fmt.Errorf("%w: gauge value must be a number", errUsage)
+		}
+		metric.Value = &value
+	default:
+		return fmt.Errorf("%w: set supports gauge and counter", errUsage)
+	}
+	resp, err := c.post(ctx, "update", metric)
+	if err != nil {
+		return err
+	}
+	var res memstorage.Metrics
+	if err := json.Unmarshal(resp.Body(), &res); err != nil {
+		return err
+	}
+	return writeMetric(c.out, c.format("table"), &res)
+}
+
+// fetchAll читает все страницы /api/metrics с параметрами query.
+func (c *ctl) fetchAll(ctx context.Context, query url.Values) ([]memstorage.Metrics, error) {
+	var res []memstorage.Metrics
+	query.Set("limit", strconv.Itoa(pageSize))
+	for {
+		resp, err := c.client.R().SetContext(ctx).SetQueryParamsFromValues(query).Get(c.addr.AddrEmpty() + "api/metrics")
+		if err := checkResponse(resp, err); err != nil {
+			return nil, err
+		}
+		var page []memstorage.Metrics
+		if err := json.Unmarshal(resp.Body(), &page); err != nil {
+			return nil, err
+		}
+		res = append(res, page...)
+		cursor := resp.Header().Get(api.NextCursorHeader)
+		if cursor == "" {
+			return res, nil
+		}
+		query.Set("cursor", cursor)
+	}
+}
+
+func (c *ctl) list(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	mType := fs.String("type", "", "Comma separated metric types")
+	prefix := fs.String("prefix", "", "Metric name prefix")
+	match := fs.String("match", "", "Regular expression for metric names")
+	var labels labelFlags
+	fs.Var(&labels, "label", "Label filter key=value, can be repeated")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	query := url.Values{}
+	for k, v := range map[string]string{"type": *mType, "prefix": *prefix, "match": *match} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	for _, l := range labels {
+		query.Add("label", l)
+	}
+	metrics, err := c.fetchAll(ctx, query)
+	if err != nil {
+		return err
+	}
+	return writeMetrics(c.out, c.format("table"), metrics)
+}
+
+func (c *ctl) export(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	metrics, err := c.fetchAll(ctx, url.Values{})
+	if err != nil {
+		return err
+	}
+	if metrics == nil {
+		metrics = []memstorage.Metrics{}
+	}
+	return writeMetrics(c.out, c.format("json"), metrics)
+}
+
+// watch печатает события /stream, пока сервер не закроет соединение или
+// пользователь не прервёт команду.
+func (c *ctl) watch(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	mType := fs.String("type", "", "Comma separated metric types")
+	prefix := fs.String("prefix", "", "Metric name prefix")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	w, err := newRowWriter(c.out, c.format("table"))
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.R().SetContext(ctx).SetDoNotParseResponse(true).
+		SetQueryParams(map[string]string{"type": *mType, "prefix": *prefix}).
+		Get(c.addr.AddrEmpty() + "stream")
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	body := resp.RawBody()
+	defer body.Close()
+	if !resp.IsSuccess() {
+		return fmt.Errorf("server responded %s", resp.Status())
+	}
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var metric memstorage.Metrics
+		if err := json.Unmarshal([]byte(data), &metric); err != nil {
+			return err
+		}
+		if err := w.Write(&metric); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
+
+// diffMetrics превращает значения из файла в обновления для /updates/.
+// Gauge отправляются как есть. Counter и histogram на сервере накапливаются,
+// поэтому из них вычитаются текущие значения current, а метрики без изменений
+// пропускаются. Гистограммы, которые нельзя свести к значению из файла,
+// возвращаются в errs.
+func diffMetrics(metrics, current []memstorage.Metrics) (updates []memstorage.Metrics, errs []error) {
+	known := make(map[string]*memstorage.Metrics, len(current))
+	for i := range current {
+		m := &current[i]
+		known[m.MType+" "+memstorage.SeriesKey(m.ID, m.Labels)] = m
+	}
+	for _, m := range metrics {
+		cur, ok := known[m.MType+" "+memstorage.SeriesKey(m.ID, m.Labels)]
+		if !ok {
+			updates = append(updates, m)
+			continue
+		}
+		switch {
+		case m.MType == "counter" && m.Delta != nil && cur.Delta != nil:
+			delta := *m.Delta - *cur.Delta
+			if delta == 0 {
+				continue
+			}
+			m.Delta = &delta
+		case m.MType == "histogram" && m.Histogram != nil && cur.Histogram != nil:
+			hist, err := m.Histogram.Sub(cur.Histogram)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", memstorage.SeriesKey(m.ID, m.Labels), err))
+				continue
+			}
+			if hist.Count == 0 {
+				continue
+			}
+			m.Histogram = hist
+		}
+		updates = append(updates, m)
+	}
+	return updates, errs
+}
+
+// pushFile записывает на сервер значения из файла в формате export, так что
+// повторная загрузка того же файла ничего не меняет.
+func (c *ctl) pushFile(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	var (
+		data []byte
+		err  error
+	)
+	if args[0] == "-" {
+		data, err = io.ReadAll(c.in)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return err
+	}
+	var metrics []memstorage.Metrics
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		return fmt.Errorf("file must contain a JSON array of metrics: %w", err)
+	}
+	current, err := c.fetchAll(ctx, url.Values{"type": {"counter,histogram"}})
+	if err != nil {
+		return err
+	}
+	updates, errs := diffMetrics(metrics, current)
+
+	for start := 0; start < len(updates); start += pushBatch {
+		end := start + pushBatch
+		if end > len(updates) {
+			end = len(updates)
+		}
+		if _, err := c.post(ctx, "updates", updates[start:end]); err != nil {
+			return fmt.Errorf("pushed %d of %d metrics: %w", start, len(updates), err)
+		}
+	}
+	if _, err = fmt.Fprintf(c.out, "pushed %d metrics, %d unchanged\n", len(updates), len(metrics)-len(updates)-len(errs)); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("histograms not pushed: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (c *ctl) ping(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	resp, err := c.client.R().SetContext(ctx).Get(c.addr.AddrEmpty() + "ping")
+	if err := checkResponse(resp, err); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.out, "ok")
+	return err
+}
+
+func main() {
+	config := getVars()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	addr := addressurl.AddressURL{Protocol: "http", Address: config.Address}
+	var tlsConfig *tls.Config
+	if config.TLSCA != "" || config.TLSCert != "" {
+		var err error
+		tlsConfig, err = tlsconfig.Client(config.TLSCA, config.TLSCert, config.TLSKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "metricsctl: "+err.Error())
+			os.Exit(1)
+		}
+		addr.Protocol = "https"
+	}
+	var pub *rsa.PublicKey
+	if config.CryptoKey != "" {
+		var err error
+		pub, err = envelope.LoadPublicKey(config.CryptoKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "metricsctl: "+err.Error())
+			os.Exit(1)
+		}
+	}
+	// адрес для проверки доверенной подсети сервера, как у агента
+	realIP, err := httpclient.OutboundIP(config.Address)
+	if err != nil {
+		realIP = ""
+	}
+	c := &ctl{
+		addr:   addr,
+		client: httpclient.New(tlsConfig, realIP),
+		key:    config.Key,
+		pub:    pub,
+		output: config.Output,
+		out:    os.Stdout,
+		in:     os.Stdin,
+	}
+	err = c.run(ctx, flag.Args())
+	stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "metricsctl: "+err.Error())
+		if errors.Is(err, errUsage) {
+			flag.Usage()
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
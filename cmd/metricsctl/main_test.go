@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kishenkoilya/metricsalerts/internal/addressurl"
+	"github.com/kishenkoilya/metricsalerts/internal/api"
+	"github.com/kishenkoilya/metricsalerts/internal/envelope"
+	"github.com/kishenkoilya/metricsalerts/internal/httpclient"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+)
+
+// stubServer отвечает как сервер метрик и проверяет подпись запросов.
+func stubServer(t *testing.T, key string, pushed *int) *httptest.Server {
+	readMetrics := func(r *http.Request, v interface{}) bool {
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return false
+		}
+		data, _ := io.ReadAll(reader)
+		if r.Header.Get(httpclient.SignHeader) != httpclient.Sign(data, key) {
+			return false
+		}
+		return json.Unmarshal(data, v) == nil
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/value/", func(w http.ResponseWriter, r *http.Request) {
+		var m memstorage.Metrics
+		if !readMetrics(r, &m) {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if m.ID != "HeapAlloc" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		value := 2.5
+		m.Value = &value
+		json.NewEncoder(w).Encode(m)
+	})
+	mux.HandleFunc("/update/", func(w http.ResponseWriter, r *http.Request) {
+		var m memstorage.Metrics
+		if !readMetrics(r, &m) {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(m)
+	})
+	mux.HandleFunc("/updates/", func(w http.ResponseWriter, r *http.Request) {
+		var metrics []memstorage.Metrics
+		if !readMetrics(r, &metrics) {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		*pushed += len(metrics)
+		json.NewEncoder(w).Encode(metrics)
+	})
+	mux.HandleFunc("/api/metrics", func(w http.ResponseWriter, r *http.Request) {
+		value, delta := 1.0, int64(4)
+		if r.URL.Query().Get("cursor") == "" {
+			w.Header().Set(api.NextCursorHeader, "next")
+			json.NewEncoder(w).Encode([]memstorage.Metrics{{ID: "PollCount", MType: "counter", Delta: &delta}})
+			return
+		}
+		json.NewEncoder(w).Encode([]memstorage.Metrics{{ID: "Alloc", MType: "gauge", Value: &value}})
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, ": connected\n\n")
+		io.WriteString(w, "event: gauge\ndata: {\"id\":\"Alloc\",\"type\":\"gauge\",\"value\":3}\n\n")
+		io.WriteString(w, ": ping\n\n")
+		io.WriteString(w, "event: counter\ndata: {\"id\":\"PollCount\",\"type\":\"counter\",\"delta\":5}\n\n")
+	})
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {})
+	return httptest.NewServer(mux)
+}
+
+func Test_ctl_run(t *testing.T) {
+	pushed := 0
+	srv := stubServer(t, "secret", &pushed)
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		args    []string
+		output  string
+		in      string
+		want    string
+		wantErr error
+	}{
+		{name: "Test1", args: []string{"get", "gauge", "HeapAlloc"}, output: "csv", want: "type,name,labels,value\ngauge,HeapAlloc,,2.5\n"},
+		{name: "Test2", args: []string{"get", "gauge", "Missing"}, wantErr: errors.New("server responded 404")},
+		{name: "Test3", args: []string{"set", "counter", "PollCount", "5", "host=a"}, output: "json",
+			want: "{\n  \"id\": \"PollCount\",\n  \"type\": \"counter\",\n  \"delta\": 5,\n  \"labels\": {\n    \"host\": \"a\"\n  }\n}\n"},
+		{name: "Test4", args: []string{"set", "counter", "PollCount", "1.5"}, wantErr: errUsage},
+		{name: "Test5", args: []string{"list", "-type", "gauge,counter"}, output: "csv", want: "type,name,labels,value\ncounter,PollCount,,4\ngauge,Alloc,,1\n"},
+		{name: "Test6", args: []string{"export"}, want: "[\n  {\n    \"id\": \"PollCount\",\n    \"type\": \"counter\",\n    \"delta\": 4\n  },\n" +
+			"  {\n    \"id\": \"Alloc\",\n    \"type\": \"gauge\",\n    \"value\": 1\n  }\n]\n"},
+		{name: "Test7", args: []string{"push-file", "-"}, in: `[{"id": "Alloc", "type": "gauge", "value": 1}, {"id": "PollCount", "type": "counter", "delta": 2}]`,
+			want: "pushed 2 metrics, 0 unchanged\n"},
+		{name: "Test8", args: []string{"push-file", "-"}, in: `{"id": "Alloc"}`, wantErr: errors.New("JSON array")},
+		{name: "Test9", args: []string{"watch"}, output: "json", want: "{\"id\":\"Alloc\",\"type\":\"gauge\",\"value\":3}\n{\"id\":\"PollCount\",\"type\":\"counter\",\"delta\":5}\n"},
+		{name: "Test10", args: []string{"ping"}, want: "ok\n"},
+		{name: "Test11", args: []string{"delete"}, wantErr: errUsage},
+		{name: "Test12", args: []string{"ping"}, output: "xml", wantErr: errUsage},
+		{name: "Test13", args: []string{"push-file", "-"}, in: `[{"id": "PollCount", "type": "counter", "delta": 4}]`,
+			want: "pushed 0 metrics, 1 unchanged\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			c := &ctl{
+				addr:   addressurl.AddressURL{Protocol: "http", Address: strings.TrimPrefix(srv.URL, "http://")},
+				client: httpclient.New(nil, ""),
+				key:    "secret",
+				output: tt.output,
+				out:    &out,
+				in:     strings.NewReader(tt.in),
+			}
+			err := c.run(context.Background(), tt.args)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("run() error = %v", err)
+			case tt.wantErr == errUsage && !errors.Is(err, errUsage):
+				t.Fatalf("run() error = %v, want usage error", err)
+			case tt.wantErr != nil && (err == nil || !strings.Contains(err.Error(), tt.wantErr.Error())):
+				t.Fatalf("run() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && out.String() != tt.want {
+				t.Errorf("run() output =\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+	if pushed != 2 {
+		t.Errorf("server received %d pushed metrics, want 2", pushed)
+	}
+}
+
+// Test_ctl_exportPushFile проверяет, что загрузка выгрузки обратно не
+// удваивает накопительные метрики.
+func Test_ctl_exportPushFile(t *testing.T) {
+	store := make(map[string]memstorage.Metrics)
+	var order []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/updates/", func(w http.ResponseWriter, r *http.Request) {
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var metrics []memstorage.Metrics
+		if err := json.NewDecoder(reader).Decode(&metrics); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		for _, m := range metrics {
+			key := m.MType + " " + memstorage.SeriesKey(m.ID, m.Labels)
+			cur, ok := store[key]
+			if !ok {
+				order = append(order, key)
+			}
+			switch {
+			case ok && m.MType == "counter":
+				delta := *cur.Delta + *m.Delta
+				m.Delta = &delta
+			case ok && m.MType == "histogram":
+				hist := cur.Histogram.Copy()
+				if err := hist.Merge(m.Histogram); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				m.Histogram = hist
+			}
+			store[key] = m
+		}
+	})
+	mux.HandleFunc("/api/metrics", func(w http.ResponseWriter, r *http.Request) {
+		res := []memstorage.Metrics{}
+		for _, key := range order {
+			res = append(res, store[key])
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	in := `[{"id": "Alloc", "type": "gauge", "value": 1.5}, {"id": "PollCount", "type": "counter", "delta": 7, "labels": {"host": "a"}},
+		{"id": "Latency", "type": "histogram", "histogram": {"bounds": [1], "counts": [1, 2], "sum": 5.5, "count": 3}}]`
+	wants := []string{"pushed 3 metrics, 0 unchanged\n", "pushed 1 metrics, 2 unchanged\n"}
+	var export string
+	for i, want := range wants {
+		var out bytes.Buffer
+		c := &ctl{
+			addr:   addressurl.AddressURL{Protocol: "http", Address: strings.TrimPrefix(srv.URL, "http://")},
+			client: httpclient.New(nil, ""),
+			out:    &out,
+			in:     strings.NewReader(in),
+		}
+		if err := c.run(context.Background(), []string{"push-file", "-"}); err != nil {
+			t.Fatalf("push-file #%d error = %v", i+1, err)
+		}
+		if out.String() != want {
+			t.Errorf("push-file #%d output = %q, want %q", i+1, out.String(), want)
+		}
+		out.Reset()
+		if err := c.run(context.Background(), []string{"export"}); err != nil {
+			t.Fatalf("export #%d error = %v", i+1, err)
+		}
+		if i > 0 && out.String() != export {
+			t.Errorf("export after second push-file =\n%s\nwant\n%s", out.String(), export)
+		}
+		export = out.String()
+		// следующая загрузка - собственная выгрузка сервера
+		in = export
+	}
+	if delta := *store["counter "+memstorage.SeriesKey("PollCount", map[string]string{"host": "a"})].Delta; delta != 7 {
+		t.Errorf("PollCount = %d, want 7", delta)
+	}
+	if count := store["histogram Latency"].Histogram.Count; count != 3 {
+		t.Errorf("Latency count = %d, want 3", count)
+	}
+}
+
+// Test_ctl_cryptoKey проверяет, что записи шифруются открытым ключом сервера,
+// а запросы /value/ уходят как есть.
+func Test_ctl_cryptoKey(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := make(map[string]bool)
+	mux := http.NewServeMux()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		encrypted[r.URL.Path] = r.Header.Get(envelope.Header) == envelope.Scheme
+		if encrypted[r.URL.Path] {
+			if body, err = envelope.Open(priv, body); err != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+		}
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var m memstorage.Metrics
+		if err := json.NewDecoder(reader).Decode(&m); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(m)
+	}
+	mux.HandleFunc("/update/", handler)
+	mux.HandleFunc("/value/", handler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := &ctl{
+		addr:   addressurl.AddressURL{Protocol: "http", Address: strings.TrimPrefix(srv.URL, "http://")},
+		client: httpclient.New(nil, ""),
+		pub:    &priv.PublicKey,
+		out:    io.Discard,
+	}
+	if err := c.run(context.Background(), []string{"set", "gauge", "Alloc", "1"}); err != nil {
+		t.Fatalf("set error = %v", err)
+	}
+	if err := c.run(context.Background(), []string{"get", "gauge", "Alloc"}); err != nil {
+		t.Fatalf("get error = %v", err)
+	}
+	if !encrypted["/update/"] {
+		t.Errorf("/update/ body is not encrypted")
+	}
+	if encrypted["/value/"] {
+		t.Errorf("/value/ body is encrypted")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+)
+
+var errFormat = errors.New("unknown output format, use table, json or csv")
+
+func metricValue(m *memstorage.Metrics) string {
+	switch {
+	case m.Histogram != nil:
+		return fmt.Sprintf("count=%d sum=%g", m.Histogram.Count, m.Histogram.Sum)
+	case m.Delta != nil:
+		return strconv.FormatInt(*m.Delta, 10)
+	case m.Value != nil:
+		return strconv.FormatFloat(*m.Value, 'g', -1, 64)
+	}
+	return ""
+}
+
+// rowWriter печатает метрики по одной: таблицей, CSV или JSON по объекту
+// на строку. Таблица выравнивается при Flush.
+type rowWriter struct {
+	tw  *tabwriter.Writer
+	csv *csv.Writer
+	enc *json.Encoder
+	// заголовок печатается перед первой строкой
+	header bool
+}
+
+func newRowWriter(out io.Writer, format string) (*rowWriter, error) {
+	switch format {
+	case "table":
+		return &rowWriter{tw: tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)}, nil
+	case "csv":
+		return &rowWriter{csv: csv.NewWriter(out)}, nil
+	case "json":
+		return &rowWriter{enc: json.NewEncoder(out)}, nil
+	}
+	return nil, errFormat
+}
+
+func (w *rowWriter) Write(m *memstorage.Metrics) error {
+	if w.enc != nil {
+		return w.enc.Encode(m)
+	}
+	row := []string{m.MType, m.ID, memstorage.FormatLabels(m.Labels), metricValue(m)}
+	if w.csv != nil {
+		if !w.header {
+			w.header = true
+			if err := w.csv.Write([]string{"type", "name", "labels", "value"}); err != nil {
+				return err
+			}
+		}
+		return w.csv.Write(row)
+	}
+	if !w.header {
+		w.header = true
+		if _, err := fmt.Fprintln(w.tw, "TYPE\tNAME\tLABELS\tVALUE"); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w.tw, "%s\t%s\t%s\t%s\n", row[0], row[1], row[2], row[3])
+	return err
+}
+
+func (w *rowWriter) Flush() error {
+	switch {
+	case w.csv != nil:
+		w.csv.Flush()
+		return w.csv.Error()
+	case w.tw != nil:
+		return w.tw.Flush()
+	}
+	return nil
+}
+
+// writeMetrics печатает метрики целиком; в формате json - одним массивом.
+func writeMetrics(out io.Writer, format string, metrics []memstorage.Metrics) error {
+	if format == "json" {
+		data, err := json.MarshalIndent(metrics, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	}
+	w, err := newRowWriter(out, format)
+	if err != nil {
+		return err
+	}
+	for i := range metrics {
+		if err := w.Write(&metrics[i]); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// writeMetric печатает одну метрику; в формате json - объектом.
+func writeMetric(out io.Writer, format string, m *memstorage.Metrics) error {
+	if format == "json" {
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	}
+	return writeMetrics(out, format, []memstorage.Metrics{*m})
+}
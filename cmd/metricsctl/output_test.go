@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+)
+
+func Test_writeMetrics(t *testing.T) {
+	value, delta := 1.5, int64(3)
+	h := memstorage.NewHistogram([]float64{1})
+	h.Observe(0.5)
+	metrics := []memstorage.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "HeapAlloc", MType: "gauge", Value: &value, Labels: map[string]string{"host": "a"}},
+		{ID: "latency", MType: "histogram", Histogram: h},
+	}
+	tests := []struct {
+		name    string
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{name: "Test1", format: "table", want: "TYPE       NAME       LABELS    VALUE\n" +
+			"counter    PollCount            3\n" +
+			"gauge      HeapAlloc  host=\"a\"  1.5\n" +
+			"histogram  latency              count=1 sum=0.5\n"},
+		{name: "Test2", format: "csv", want: "type,name,labels,value\ncounter,PollCount,,3\ngauge,HeapAlloc,\"host=\"\"a\"\"\",1.5\n" +
+			"histogram,latency,,count=1 sum=0.5\n"},
+		{name: "Test3", format: "yaml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := writeMetrics(&buf, tt.format, metrics)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && buf.String() != tt.want {
+				t.Errorf("writeMetrics() =\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+)
+
+const (
+	AlertInactive = "inactive"
+	AlertPending  = "pending"
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Сколько разрешённый алерт остаётся видимым в /alerts перед тем, как стать неактивным
+const resolvedAlertRetention = 15 * time.Minute
+
+// AlertRule описывает правило вида "gauge HeapAlloc > 500MB for 2m"
+// или "counter PollCount no_increase for 1m".
+type AlertRule struct {
+	Name      string            `json:"name"`
+	MType     string            `json:"type"`
+	Metric    string            `json:"metric"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Op        string            `json:"op"`
+	Threshold string            `json:"threshold,omitempty"`
+	For       string            `json:"for,omitempty"`
+
+	threshold float64
+	forDur    time.Duration
+}
+
+type Alert struct {
+	Rule       string            `json:"rule"`
+	MType      string            `json:"type"`
+	Metric     string            `json:"metric"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	State      string            `json:"state"`
+	Value      *float64          `json:"value,omitempty"`
+	ActiveAt   time.Time         `json:"activeAt"`
+	FiredAt    *time.Time        `json:"firedAt,omitempty"`
+	ResolvedAt *time.Time        `json:"resolvedAt,omitempty"`
+}
+
+type alertRuleState struct {
+	rule  AlertRule
+	alert Alert
+	// для no_increase: последнее значение счётчика и было ли оно уже прочитано
+	lastValue float64
+	seen      bool
+}
+
+type AlertEngine struct {
+	mutex   sync.RWMutex
+	storage *memstorage.MemStorage
+	rules   []*alertRuleState
+}
+
+func NewAlertEngine(storage *memstorage.MemStorage, rules []AlertRule) (*AlertEngine, error) {
+	engine := &AlertEngine{storage: storage}
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("alert rule %q: %w", rule.Name, err)
+		}
+		engine.rules = append(engine.rules, &alertRuleState{
+			rule:  rule,
+			alert: Alert{Rule: rule.Name, MType: rule.MType, Metric: rule.Metric, Labels: rule.Labels, State: AlertInactive},
+		})
+	}
+	return engine, nil
+}
+
+func LoadAlertRules(path string) ([]AlertRule, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []AlertRule
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (rule *AlertRule) validate() error {
+	if rule.Name == "" || rule.Metric == "" {
+		return errors.New("name and metric must be set")
+	}
+	if rule.MType != "counter" && rule.MType != "gauge" {
+		return errors.New("metric type not counter, nor gauge")
+	}
+	switch rule.Op {
+	case ">", ">=", "<", "<=", "==", "!=":
+		val, err := parseThreshold(rule.Threshold)
+		if err != nil {
+			return err
+		}
+		rule.threshold = val
+	case "no_increase":
+		if rule.MType != "counter" {
+			return errors.New("no_increase is only applicable to counters")
+		}
+	default:
+		return errors.New("unknown operator " + rule.Op)
+	}
+	if rule.For != "" {
+		dur, err := time.ParseDuration(rule.For)
+		if err != nil {
+			return err
+		}
+		rule.forDur = dur
+	}
+	return nil
+}
+
+// parseThreshold понимает числа с необязательными суффиксами KB, MB, GB (степени 1024).
+func parseThreshold(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	multipliers := []struct {
+		suffix string
+		mult   float64
+	}{
+		{"KB", 1 << 10},
+		{"MB", 1 << 20},
+		{"GB", 1 << 30},
+	}
+	mult := 1.0
+	for _, m := range multipliers {
+		if strings.HasSuffix(strings.ToUpper(s), m.suffix) {
+			s = strings.TrimSpace(s[:len(s)-len(m.suffix)])
+			mult = m.mult
+			break
+		}
+	}
+	val, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad threshold: %w", err)
+	}
+	return val * mult, nil
+}
+
+func compareThreshold(op string, val, threshold float64) bool {
+	switch op {
+	case ">":
+		return val > threshold
+	case ">=":
+		return val >= threshold
+	case "<":
+		return val < threshold
+	case "<=":
+		return val <= threshold
+	case "==":
+		return val == threshold
+	case "!=":
+		return val != threshold
+	}
+	return false
+}
+
+func (e *AlertEngine) readValue(mType, mName string, labels map[string]string) (float64, bool) {
+	key := memstorage.SeriesKey(mName, labels)
+	if mType == "gauge" {
+		return e.storage.GetGauge(key)
+	}
+	val, ok := e.storage.GetCounter(key)
+	return float64(val), ok
+}
+
+// Evaluate проверяет все правила на момент now и возвращает алерты,
+// которые перешли в состояние firing или resolved.
+func (e *AlertEngine) Evaluate(now time.Time) []Alert {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var changed []Alert
+	for _, rs := range e.rules {
+		val, ok := e.readValue(rs.rule.MType, rs.rule.Metric, rs.rule.Labels)
+		active := false
+		if ok {
+			if rs.rule.Op == "no_increase" {
+				active = rs.seen && val <= rs.lastValue
+				rs.lastValue = val
+				rs.seen = true
+			} else {
+				active = compareThreshold(rs.rule.Op, val, rs.rule.threshold)
+			}
+			v := val
+			rs.alert.Value = &v
+		}
+
+		alert := &rs.alert
+		switch {
+		case active && (alert.State == AlertInactive || alert.State == AlertResolved):
+			alert.State = AlertPending
+			alert.ActiveAt = now
+			alert.FiredAt = nil
+			alert.ResolvedAt = nil
+			if rs.rule.forDur == 0 {
+				alert.State = AlertFiring
+				alert.FiredAt = &now
+				changed = append(changed, *alert)
+			}
+		case active && alert.State == AlertPending:
+			if now.Sub(alert.ActiveAt) >= rs.rule.forDur {
+				alert.State = AlertFiring
+				alert.FiredAt = &now
+				changed = append(changed, *alert)
+			}
+		case !active && alert.State == AlertPending:
+			alert.State = AlertInactive
+		case !active && alert.State == AlertFiring:
+			alert.State = AlertResolved
+			alert.ResolvedAt = &now
+			changed = append(changed, *alert)
+		case !active && alert.State == AlertResolved:
+			if now.Sub(*alert.ResolvedAt) >= resolvedAlertRetention {
+				alert.State = AlertInactive
+			}
+		}
+	}
+	return changed
+}
+
+// Alerts возвращает все алерты, кроме неактивных.
+func (e *AlertEngine) Alerts() []Alert {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	res := make([]Alert, 0)
+	for _, rs := range e.rules {
+		if rs.alert.State != AlertInactive {
+			res = append(res, rs.alert)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Rule < res[j].Rule })
+	return res
+}
+
+// Run периодически вычисляет правила; notifier может быть nil.
+func (e *AlertEngine) Run(ctx context.Context, interval time.Duration, notifier *WebhookNotifier) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			changed := e.Evaluate(now)
+			for _, alert := range changed {
+				sugar.Infoln("alert", alert.Rule, "state", alert.State)
+			}
+			if notifier != nil && len(changed) > 0 {
+				notifier.Notify(changed)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func alertsPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	handlerVars := r.Context().Value(HandlerVars{}).(*HandlerVars)
+	sugar.Infoln("alertsPage")
+	respJSON, err := json.Marshal(handlerVars.alerts.Alerts())
+	if err != nil {
+		http.Error(w, "json.Marshal failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respJSON)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+)
+
+func Test_parseThreshold(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    float64
+		wantErr bool
+	}{
+		{name: "Test1", s: "0.5", want: 0.5},
+		{name: "Test2", s: "500MB", want: 500 * 1024 * 1024},
+		{name: "Test3", s: "2 kb", want: 2048},
+		{name: "Test4", s: "lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseThreshold(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseThreshold() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseThreshold() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlertEngine_Evaluate(t *testing.T) {
+	storage := memstorage.NewMemStorage()
+	engine, err := NewAlertEngine(storage, []AlertRule{
+		{Name: "HighHeap", MType: "gauge", Metric: "HeapAlloc", Op: ">", Threshold: "500MB", For: "2m"},
+		{Name: "PollStalled", MType: "counter", Metric: "PollCount", Op: "no_increase", For: "1m"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type step struct {
+		at        time.Duration
+		heap      float64
+		pollDelta int64
+		wantHeap  string
+		wantPoll  string
+	}
+	steps := []step{
+		{at: 0, heap: 100 << 20, pollDelta: 1, wantHeap: AlertInactive, wantPoll: AlertInactive},
+		{at: 30 * time.Second, heap: 600 << 20, pollDelta: 1, wantHeap: AlertPending, wantPoll: AlertInactive},
+		{at: 90 * time.Second, heap: 600 << 20, pollDelta: 0, wantHeap: AlertPending, wantPoll: AlertPending},
+		{at: 150 * time.Second, heap: 700 << 20, pollDelta: 0, wantHeap: AlertFiring, wantPoll: AlertFiring},
+		{at: 180 * time.Second, heap: 100 << 20, pollDelta: 1, wantHeap: AlertResolved, wantPoll: AlertResolved},
+		{at: 180*time.Second + resolvedAlertRetention, heap: 100 << 20, pollDelta: 1, wantHeap: AlertInactive, wantPoll: AlertInactive},
+	}
+	for i, st := range steps {
+		storage.PutGauge("HeapAlloc", st.heap)
+		storage.PutCounter("PollCount", st.pollDelta)
+		engine.Evaluate(start.Add(st.at))
+
+		states := map[string]string{"HighHeap": AlertInactive, "PollStalled": AlertInactive}
+		for _, alert := range engine.Alerts() {
+			states[alert.Rule] = alert.State
+		}
+		if states["HighHeap"] != st.wantHeap {
+			t.Errorf("step %d: HighHeap state = %v, want %v", i, states["HighHeap"], st.wantHeap)
+		}
+		if states["PollStalled"] != st.wantPoll {
+			t.Errorf("step %d: PollStalled state = %v, want %v", i, states["PollStalled"], st.wantPoll)
+		}
+	}
+}
+
+func TestNewAlertEngine(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    AlertRule
+		wantErr bool
+	}{
+		{name: "Test1", rule: AlertRule{Name: "a", MType: "gauge", Metric: "Alloc", Op: "<=", Threshold: "10"}},
+		{name: "Test2", rule: AlertRule{Name: "a", MType: "gauge", Metric: "Alloc", Op: "no_increase"}, wantErr: true},
+		{name: "Test3", rule: AlertRule{Name: "a", MType: "histogram", Metric: "Alloc", Op: ">", Threshold: "1"}, wantErr: true},
+		{name: "Test4", rule: AlertRule{Name: "a", MType: "counter", Metric: "PollCount", Op: "no_increase", For: "soon"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAlertEngine(memstorage.NewMemStorage(), []AlertRule{tt.rule})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewAlertEngine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/api"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+)
+
+const (
+	apiDefaultLimit = 1000
+	apiMaxLimit     = 10000
+)
+
+// listKey - ключ сортировки /api/metrics: тип, имя, метки. Курсор - это
+// ключ последней выданной метрики, поэтому страницы не сдвигаются при
+// добавлении новых метрик.
+func listKey(m *memstorage.Metrics) string {
+	return m.MType + "\x00" + m.ID + "\x00" + memstorage.FormatLabels(m.Labels)
+}
+
+// metricsFilter - условия отбора /api/metrics, пустые поля не проверяются.
+type metricsFilter struct {
+	types  map[string]bool
+	prefix string
+	re     *regexp.Regexp
+	labels map[string]string
+}
+
+func (f *metricsFilter) match(m *memstorage.Metrics) bool {
+	if len(f.types) > 0 && !f.types[m.MType] {
+		return false
+	}
+	if !strings.HasPrefix(m.ID, f.prefix) {
+		return false
+	}
+	if f.re != nil && !f.re.MatchString(m.ID) {
+		return false
+	}
+	for k, v := range f.labels {
+		if got, ok := m.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// apiMetricsPage отдаёт метрики JSON-массивом. Параметры: type (через запятую),
+// prefix, match (регулярное выражение для имени), label=key=value,
+// limit и cursor из заголовка X-Next-Cursor предыдущего ответа.
+func apiMetricsPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	handlerVars := r.Context().Value(HandlerVars{}).(*HandlerVars)
+	sugar.Infoln("apiMetricsPage")
+	query := r.URL.Query()
+
+	filter := metricsFilter{prefix: query.Get("prefix")}
+	if types := query.Get("type"); types != "" {
+		filter.types = make(map[string]bool)
+		for _, t := range strings.Split(types, ",") {
+			filter.types[strings.TrimSpace(t)] = true
+		}
+	}
+	if match := query.Get("match"); match != "" {
+		re, err := regexp.Compile(match)
+		if err != nil {
+			http.Error(w, "Error parsing match", http.StatusBadRequest)
+			return
+		}
+		filter.re = re
+	}
+	labels, err := parseLabelParams(query["label"])
+	if err != nil {
+		http.Error(w, "Error parsing labels", http.StatusBadRequest)
+		return
+	}
+	filter.labels = labels
+
+	limit := apiDefaultLimit
+	if s := query.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Error parsing limit", http.StatusBadRequest)
+			return
+		}
+		if limit > apiMaxLimit {
+			limit = apiMaxLimit
+		}
+	}
+	var after string
+	if s := query.Get("cursor"); s != "" {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			http.Error(w, "Error parsing cursor", http.StatusBadRequest)
+			return
+		}
+		after = string(b)
+	}
+
+	metrics, err := handlerVars.storage.List(r.Context())
+	if err != nil {
+		http.Error(w, "Error listing metrics", storageStatus(err))
+		return
+	}
+	sort.SliceStable(metrics, func(i, j int) bool {
+		return listKey(&metrics[i]) < listKey(&metrics[j])
+	})
+
+	res := make([]memstorage.Metrics, 0)
+	for i := range metrics {
+		m := &metrics[i]
+		if (after != "" && listKey(m) <= after) || !filter.match(m) {
+			continue
+		}
+		if len(res) == limit {
+			w.Header().Set(api.NextCursorHeader, base64.RawURLEncoding.EncodeToString([]byte(listKey(&res[len(res)-1]))))
+			break
+		}
+		res = append(res, *m)
+	}
+
+	respJSON, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, "json.Marshal failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respJSON)
+}
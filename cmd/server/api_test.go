@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/api"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+func Test_apiMetricsPage(t *testing.T) {
+	mem := memstorage.NewMemStorage()
+	value, delta := 1.0, int64(1)
+	mem.SaveMetrics(&[]memstorage.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "HeapAlloc", MType: "gauge", Value: &value},
+		{ID: "HeapIdle", MType: "gauge", Value: &value},
+		{ID: "HeapAlloc", MType: "gauge", Value: &value, Labels: map[string]string{"host": "a"}},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "latency", MType: "histogram", Histogram: memstorage.NewHistogram(memstorage.DefaultBuckets)},
+	})
+	handlerVars := &HandlerVars{storage: storage.NewMemoryStorage(mem)}
+	router := httprouter.New()
+	router.GET("/api/metrics", ParamsMiddleware(apiMetricsPage, handlerVars))
+
+	get := func(query string) (*httptest.ResponseRecorder, []string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/metrics?"+query, nil))
+		var metrics []memstorage.Metrics
+		json.Unmarshal(w.Body.Bytes(), &metrics)
+		ids := make([]string, len(metrics))
+		for i, m := range metrics {
+			ids[i] = m.MType + ":" + m.ID + "{" + memstorage.FormatLabels(m.Labels) + "}"
+		}
+		return w, ids
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   []string
+	}{
+		{name: "Test1", status: http.StatusOK, want: []string{"counter:PollCount{}", "gauge:Alloc{}", "gauge:HeapAlloc{}",
+			`gauge:HeapAlloc{host="a"}`, "gauge:HeapIdle{}", "histogram:latency{}"}},
+		{name: "Test2", query: "type=gauge&prefix=Heap", status: http.StatusOK, want: []string{"gauge:HeapAlloc{}", `gauge:HeapAlloc{host="a"}`, "gauge:HeapIdle{}"}},
+		{name: "Test3", query: "match=" + url.QueryEscape("^(Poll|Alloc)"), status: http.StatusOK, want: []string{"counter:PollCount{}", "gauge:Alloc{}"}},
+		{name: "Test4", query: "label=host=a", status: http.StatusOK, want: []string{`gauge:HeapAlloc{host="a"}`}},
+		{name: "Test5", query: "type=counter,histogram", status: http.StatusOK, want: []string{"counter:PollCount{}", "histogram:latency{}"}},
+		{name: "Test6", query: "match=(", status: http.StatusBadRequest},
+		{name: "Test7", query: "limit=0", status: http.StatusBadRequest},
+		{name: "Test8", query: "cursor=!!!", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, got := get(tt.query)
+			if w.Code != tt.status {
+				t.Fatalf("apiMetricsPage() status = %v, want %v", w.Code, tt.status)
+			}
+			if tt.status == http.StatusOK && len(got) != len(tt.want) {
+				t.Fatalf("apiMetricsPage() = %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("apiMetricsPage() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	// постраничный обход возвращает все метрики ровно один раз
+	var all []string
+	cursor := ""
+	for pages := 0; pages < 10; pages++ {
+		w, ids := get("limit=4&cursor=" + cursor)
+		all = append(all, ids...)
+		cursor = w.Header().Get(api.NextCursorHeader)
+		if cursor == "" {
+			break
+		}
+	}
+	_, want := get("")
+	if len(all) != len(want) {
+		t.Fatalf("paged = %v, want %v", all, want)
+	}
+	for i := range want {
+		if all[i] != want[i] {
+			t.Errorf("paged = %v, want %v", all, want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+const (
+	// окно истории для спарклайнов и число точек в нём
+	sparklineWindow = 15 * time.Minute
+	sparklinePoints = 60
+	sparklineWidth  = 120
+	sparklineHeight = 24
+	// период автообновления страницы в секундах по умолчанию
+	dashboardRefresh = 10
+)
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(dashboardHTML))
+
+type dashboardRow struct {
+	Name   string
+	Value  string
+	Age    string
+	Points string
+	value  float64
+	// -1, если время обновления неизвестно
+	age time.Duration
+}
+
+type dashboardData struct {
+	Query    string
+	Sort     string
+	Desc     bool
+	Refresh  int
+	History  bool
+	Gauges   []dashboardRow
+	Counters []dashboardRow
+}
+
+// dashboardTable - данные для шаблона одной таблицы.
+type dashboardTable struct {
+	*dashboardData
+	Title  string
+	Rows   []dashboardRow
+	Window string
+	Width  int
+	Height int
+}
+
+func (d *dashboardData) Table(title string, rows []dashboardRow) dashboardTable {
+	return dashboardTable{dashboardData: d, Title: title, Rows: rows, Window: strings.TrimSuffix(sparklineWindow.String(), "0s"),
+		Width: sparklineWidth, Height: sparklineHeight}
+}
+
+// SortURL возвращает ссылку на сортировку по колонке col; повторный щелчок
+// по текущей колонке меняет направление.
+func (d *dashboardData) SortURL(col string) string {
+	v := url.Values{}
+	if d.Query != "" {
+		v.Set("q", d.Query)
+	}
+	v.Set("sort", col)
+	if col == d.Sort && !d.Desc {
+		v.Set("desc", "1")
+	}
+	v.Set("refresh", strconv.Itoa(d.Refresh))
+	return "/?" + v.Encode()
+}
+
+func (d *dashboardData) Arrow(col string) string {
+	switch {
+	case col != d.Sort:
+		return ""
+	case d.Desc:
+		return " ▼"
+	default:
+		return " ▲"
+	}
+}
+
+// sparkline переводит сэмплы в координаты polyline для окна [from, from+window].
+func sparkline(samples []memstorage.Sample, from time.Time, window time.Duration) string {
+	if len(samples) < 2 {
+		return ""
+	}
+	lo, hi := samples[0].Value, samples[0].Value
+	for _, s := range samples {
+		if s.Value < lo {
+			lo = s.Value
+		}
+		if s.Value > hi {
+			hi = s.Value
+		}
+	}
+	var b strings.Builder
+	for i, s := range samples {
+		x := float64(sparklineWidth) * float64(s.Time.Sub(from)) / float64(window)
+		// постоянное значение рисуется линией посередине
+		y := float64(sparklineHeight) / 2
+		if hi > lo {
+			// одна точка отступа сверху и снизу, чтобы линия не обрезалась
+			y = 1 + float64(sparklineHeight-2)*(hi-s.Value)/(hi-lo)
+		}
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%.1f,%.1f", x, y)
+	}
+	return b.String()
+}
+
+func formatAge(age time.Duration) string {
+	if age < 0 {
+		return "—"
+	}
+	return age.Round(time.Second).String() + " ago"
+}
+
+func sortRows(rows []dashboardRow, col string, desc bool) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if desc {
+			a, b = b, a
+		}
+		switch col {
+		case "value":
+			return a.value < b.value
+		case "age":
+			return a.age < b.age
+		default:
+			return a.Name < b.Name
+		}
+	})
+}
+
+// dashboardPage отдаёт HTML-страницу с таблицами gauge и counter метрик.
+// Параметры: q - поиск по имени, sort (name, value, age), desc, refresh - период
+// автообновления в секундах, 0 отключает его.
+func dashboardPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	handlerVars := r.Context().Value(HandlerVars{}).(*HandlerVars)
+	sugar.Infoln("dashboardPage")
+	query := r.URL.Query()
+
+	data := &dashboardData{
+		Query:   query.Get("q"),
+		Sort:    query.Get("sort"),
+		Desc:    query.Get("desc") != "",
+		Refresh: dashboardRefresh,
+		History: true,
+	}
+	switch data.Sort {
+	case "":
+		data.Sort = "name"
+	case "name", "value", "age":
+	default:
+		http.Error(w, "Error parsing sort", http.StatusBadRequest)
+		return
+	}
+	if s := query.Get("refresh"); s != "" {
+		refresh, err := strconv.Atoi(s)
+		if err != nil || refresh < 0 {
+			http.Error(w, "Error parsing refresh", http.StatusBadRequest)
+			return
+		}
+		data.Refresh = refresh
+	}
+
+	metrics, err := handlerVars.storage.List(r.Context())
+	if err != nil {
+		http.Error(w, "storage.List failed", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	from := now.Add(-sparklineWindow)
+	search := strings.ToLower(data.Query)
+	for _, m := range metrics {
+		name := memstorage.SeriesKey(m.ID, m.Labels)
+		if m.MType == "histogram" || !strings.Contains(strings.ToLower(name), search) {
+			continue
+		}
+		row := dashboardRow{Name: name, age: -1}
+		if m.MType == "counter" {
+			row.value = float64(*m.Delta)
+			row.Value = strconv.FormatInt(*m.Delta, 10)
+		} else {
+			row.value = *m.Value
+			row.Value = strconv.FormatFloat(*m.Value, 'g', -1, 64)
+		}
+		if data.History {
+			samples, err := handlerVars.storage.History(r.Context(), m.MType, m.ID, m.Labels, from, now, sparklineWindow/sparklinePoints)
+			switch {
+			case errors.Is(err, storage.ErrHistoryDisabled):
+				data.History = false
+			case err == nil && len(samples) > 0:
+				row.age = now.Sub(samples[len(samples)-1].Time)
+				row.Points = sparkline(samples, from, sparklineWindow)
+			}
+		}
+		row.Age = formatAge(row.age)
+		if m.MType == "counter" {
+			data.Counters = append(data.Counters, row)
+		} else {
+			data.Gauges = append(data.Gauges, row)
+		}
+	}
+	sortRows(data.Gauges, data.Sort, data.Desc)
+	sortRows(data.Counters, data.Sort, data.Desc)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		sugar.Errorln("dashboardTemplate.Execute failed", err.Error())
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+func Test_sparkline(t *testing.T) {
+	from := time.Unix(0, 0)
+	tests := []struct {
+		name    string
+		samples []memstorage.Sample
+		want    string
+	}{
+		{name: "Test1", samples: []memstorage.Sample{{Time: from, Value: 1}}, want: ""},
+		{name: "Test2", samples: []memstorage.Sample{{Time: from, Value: 0}, {Time: from.Add(sparklineWindow / 2), Value: 10},
+			{Time: from.Add(sparklineWindow), Value: 5}}, want: "0.0,23.0 60.0,1.0 120.0,12.0"},
+		{name: "Test3", samples: []memstorage.Sample{{Time: from, Value: 3}, {Time: from.Add(sparklineWindow), Value: 3}},
+			want: "0.0,12.0 120.0,12.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sparkline(tt.samples, from, sparklineWindow); got != tt.want {
+				t.Errorf("sparkline() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dashboardPage(t *testing.T) {
+	mem := memstorage.NewMemStorage()
+	mem.History = memstorage.NewHistory(time.Hour, 10, 1<<10)
+	mem.PutGauge("Alloc", 5)
+	mem.PutGauge("HeapAlloc", 1)
+	mem.PutGauge("HeapAlloc", 2)
+	mem.PutCounter("PollCount", 3)
+	handlerVars := &HandlerVars{storage: storage.NewMemoryStorage(mem)}
+	router := httprouter.New()
+	router.GET("/", ParamsMiddleware(dashboardPage, handlerVars))
+	router.GET("/text", ParamsMiddleware(printAllPage, handlerVars))
+
+	tests := []struct {
+		name     string
+		url      string
+		status   int
+		contains []string
+		order    []string
+		missing  []string
+	}{
+		{name: "Test1", url: "/", status: http.StatusOK,
+			contains: []string{`<meta http-equiv="refresh" content="10">`, `<td class="age">0s ago</td>`},
+			order:    []string{"Alloc", "HeapAlloc", "Counters", "PollCount"}},
+		{name: "Test2", url: "/?sort=value&desc=1&refresh=0", status: http.StatusOK,
+			order: []string{"Alloc", "HeapAlloc"}, missing: []string{"http-equiv"}},
+		{name: "Test3", url: "/?q=heap", status: http.StatusOK, contains: []string{"HeapAlloc"}, missing: []string{">Alloc<", "PollCount"}},
+		{name: "Test4", url: "/?sort=size", status: http.StatusBadRequest},
+		{name: "Test5", url: "/?refresh=-1", status: http.StatusBadRequest},
+		{name: "Test6", url: "/text", status: http.StatusOK, contains: []string{"Counters:\nPollCount: 3\nGauges:\nAlloc: 5\nHeapAlloc: 2\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %v, want %v", w.Code, tt.status)
+			}
+			body := w.Body.String()
+			for _, s := range tt.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("body does not contain %q:\n%s", s, body)
+				}
+			}
+			for _, s := range tt.missing {
+				if strings.Contains(body, s) {
+					t.Errorf("body contains %q", s)
+				}
+			}
+			pos := -1
+			for _, s := range tt.order {
+				i := strings.Index(body[pos+1:], ">"+s+"<")
+				if i < 0 {
+					t.Fatalf("%q not found in order %v", s, tt.order)
+				}
+				pos += i + 1
+			}
+		})
+	}
+}
@@ -10,12 +10,32 @@ import (
 )
 
 type Config struct {
-	Address       string `env:"ADDRESS"`
-	StoreInterval int    `env:"STORE_INTERVAL"`
-	FilePath      string `env:"FILE_STORAGE_PATH"`
-	Restore       bool   `env:"RESTORE"`
-	DatabaseDSN   string `env:"DATABASE_DSN"`
-	Key           string `env:"KEY"`
+	Address              string `env:"ADDRESS"`
+	StoreInterval        int    `env:"STORE_INTERVAL"`
+	FilePath             string `env:"FILE_STORAGE_PATH"`
+	Restore              bool   `env:"RESTORE"`
+	DatabaseDSN          string `env:"DATABASE_DSN"`
+	Key                  string `env:"KEY"`
+	AlertRules           string `env:"ALERT_RULES"`
+	AlertInterval        int    `env:"ALERT_INTERVAL"`
+	WebhookURLs          string `env:"WEBHOOK_URLS"`
+	WebhookGroupInterval int    `env:"WEBHOOK_GROUP_INTERVAL"`
+	HistoryRetention     int    `env:"HISTORY_RETENTION"`
+	HistorySamples       int    `env:"HISTORY_SAMPLES"`
+	HistoryMemoryLimit   int    `env:"HISTORY_MEMORY_LIMIT"`
+	DBSamplesRetention   int    `env:"DB_SAMPLES_RETENTION"`
+	DBMaxConns           int    `env:"DB_MAX_CONNS"`
+	DBMinConns           int    `env:"DB_MIN_CONNS"`
+	DBStatementCache     int    `env:"DB_STATEMENT_CACHE"`
+	TLSCert              string `env:"TLS_CERT"`
+	TLSKey               string `env:"TLS_KEY"`
+	TLSClientCA          string `env:"TLS_CLIENT_CA"`
+	CryptoKey            string `env:"CRYPTO_KEY"`
+	TrustedSubnet        string `env:"TRUSTED_SUBNET"`
+	GRPCAddress          string `env:"GRPC_ADDRESS"`
+	StatsDAddress        string `env:"STATSD_ADDRESS"`
+	GraphiteAddress      string `env:"GRAPHITE_ADDRESS"`
+	GraphiteRules        string `env:"GRAPHITE_RULES"`
 }
 
 func getVars() *Config {
@@ -25,6 +45,26 @@ func getVars() *Config {
 	restore := flag.Bool("r", true, "A flag that determines wether server will download metrics from file upon start")
 	psqlLine := flag.String("d", "", "A string that contains info to connect to psql")
 	key := flag.String("k", "", "Key for hash func")
+	alertRules := flag.String("alert-rules", "", "Path to JSON file with alert rules")
+	alertInterval := flag.Int("alert-interval", 10, "A time interval for evaluating alert rules")
+	webhookURLs := flag.String("webhook-urls", "", "Comma separated list of URLs to notify about alerts")
+	webhookGroupInterval := flag.Int("webhook-group-interval", 30, "A time interval for grouping alert notifications")
+	historyRetention := flag.Int("history-retention", 3600, "How long metric history is kept in seconds, 0 disables history")
+	historySamples := flag.Int("history-samples", 3600, "Max number of history samples kept per metric")
+	historyMemoryLimit := flag.Int("history-memory-limit", 64<<20, "Max memory used by metric history in bytes")
+	tlsCert := flag.String("tls-cert", "", "Path to TLS certificate, enables HTTPS")
+	tlsKey := flag.String("tls-key", "", "Path to TLS private key")
+	tlsClientCA := flag.String("tls-client-ca", "", "Path to CA certificate that agent certificates must be signed by")
+	graphiteAddress := flag.String("graphite-address", "", "TCP address to receive Graphite plaintext metrics on, empty disables Graphite")
+	graphiteRules := flag.String("graphite-rules", "", "Path to JSON file with Graphite path to metric name rules")
+	statsdAddress := flag.String("statsd-address", "", "UDP address to receive StatsD metrics on, empty disables StatsD")
+	grpcAddress := flag.String("grpc-address", "", "An address the gRPC server will listen to, empty disables gRPC")
+	trustedSubnet := flag.String("t", "", "Comma separated CIDRs that clients sending updates must belong to (X-Real-IP or the connection address), empty allows all")
+	cryptoKey := flag.String("crypto-key", "", "Path to RSA private key used to decrypt agent requests; when set, unencrypted updates are rejected")
+	dbMaxConns := flag.Int("db-max-conns", 10, "Max number of connections in the database pool")
+	dbMinConns := flag.Int("db-min-conns", 0, "Min number of idle connections in the database pool")
+	dbStatementCache := flag.Int("db-statement-cache", 512, "Number of prepared statements cached per database connection")
+	dbSamplesRetention := flag.Int("db-samples-retention", 0, "How long to keep every written value in the database samples table in seconds, 0 disables it")
 
 	flag.Parse()
 
@@ -53,11 +93,83 @@ func getVars() *Config {
 	if cfg.Key == "" {
 		cfg.Key = *key
 	}
+	if cfg.AlertRules == "" {
+		cfg.AlertRules = *alertRules
+	}
+	if cfg.AlertInterval == 0 {
+		cfg.AlertInterval = *alertInterval
+	}
+	if cfg.WebhookURLs == "" {
+		cfg.WebhookURLs = *webhookURLs
+	}
+	if cfg.WebhookGroupInterval == 0 {
+		cfg.WebhookGroupInterval = *webhookGroupInterval
+	}
+	if _, err := os.LookupEnv("HISTORY_RETENTION"); !err {
+		cfg.HistoryRetention = *historyRetention
+	}
+	if cfg.HistorySamples == 0 {
+		cfg.HistorySamples = *historySamples
+	}
+	if cfg.HistoryMemoryLimit == 0 {
+		cfg.HistoryMemoryLimit = *historyMemoryLimit
+	}
+	if cfg.DBSamplesRetention == 0 {
+		cfg.DBSamplesRetention = *dbSamplesRetention
+	}
+	if cfg.DBMaxConns == 0 {
+		cfg.DBMaxConns = *dbMaxConns
+	}
+	if cfg.DBMinConns == 0 {
+		cfg.DBMinConns = *dbMinConns
+	}
+	if cfg.DBStatementCache == 0 {
+		cfg.DBStatementCache = *dbStatementCache
+	}
+	if cfg.TLSCert == "" {
+		cfg.TLSCert = *tlsCert
+	}
+	if cfg.TLSKey == "" {
+		cfg.TLSKey = *tlsKey
+	}
+	if cfg.TLSClientCA == "" {
+		cfg.TLSClientCA = *tlsClientCA
+	}
+	if cfg.CryptoKey == "" {
+		cfg.CryptoKey = *cryptoKey
+	}
+	if cfg.TrustedSubnet == "" {
+		cfg.TrustedSubnet = *trustedSubnet
+	}
+	if cfg.GRPCAddress == "" {
+		cfg.GRPCAddress = *grpcAddress
+	}
+	if cfg.StatsDAddress == "" {
+		cfg.StatsDAddress = *statsdAddress
+	}
+	if cfg.GraphiteAddress == "" {
+		cfg.GraphiteAddress = *graphiteAddress
+	}
+	if cfg.GraphiteRules == "" {
+		cfg.GraphiteRules = *graphiteRules
+	}
+	if cfg.AlertInterval <= 0 {
+		log.Fatal("-alert-interval must be positive")
+	}
+	if cfg.WebhookGroupInterval <= 0 {
+		log.Fatal("-webhook-group-interval must be positive")
+	}
+	if cfg.TLSClientCA != "" && cfg.TLSCert == "" {
+		log.Fatal("-tls-client-ca requires -tls-cert")
+	}
 	cfg.printConfig()
 	return &cfg
 }
 
 func (conf *Config) printConfig() {
-	fmt.Printf("Address: %s; Store Interval: %d; File Path: %s; Restore: %t; Database DSN: %s;Key: %s\n",
-		conf.Address, conf.StoreInterval, conf.FilePath, conf.Restore, conf.DatabaseDSN, conf.Key)
+	fmt.Printf("Address: %s; Store Interval: %d; File Path: %s; Restore: %t; Database DSN: %s;Key: %s; Alert Rules: %s; Alert Interval: %d; Webhook URLs: %s; Webhook Group Interval: %d; History Retention: %d; History Samples: %d; History Memory Limit: %d; DB Samples Retention: %d; DB Max Conns: %d; DB Min Conns: %d; DB Statement Cache: %d; TLS Cert: %s; TLS Key: %s; TLS Client CA: %s; Crypto Key: %s; Trusted Subnet: %s; gRPC Address: %s; StatsD Address: %s; Graphite Address: %s; Graphite Rules: %s\n",
+		conf.Address, conf.StoreInterval, conf.FilePath, conf.Restore, conf.DatabaseDSN, conf.Key, conf.AlertRules, conf.AlertInterval,
+		conf.WebhookURLs, conf.WebhookGroupInterval, conf.HistoryRetention, conf.HistorySamples, conf.HistoryMemoryLimit, conf.DBSamplesRetention,
+		conf.DBMaxConns, conf.DBMinConns, conf.DBStatementCache,
+		conf.TLSCert, conf.TLSKey, conf.TLSClientCA, conf.CryptoKey, conf.TrustedSubnet, conf.GRPCAddress, conf.StatsDAddress, conf.GraphiteAddress, conf.GraphiteRules)
 }
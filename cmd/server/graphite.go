@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+// Соединение без данных дольше этого времени закрывается
+const graphiteIdleTimeout = 5 * time.Minute
+
+// Как часто сохраняются счётчики принятых и отклонённых строк
+const graphiteStatsInterval = 10 * time.Second
+
+// Сколько строк из одного соединения сохраняется одним пакетом
+const graphiteBatchSize = 1000
+
+// GraphiteRule переводит путь Graphite в имя метрики и метки. В Match каждый
+// сегмент пути сравнивается как есть, * совпадает с любым сегментом, а
+// совпавшие сегменты подставляются в Name и значения Labels как $1, $2, ...
+// Например, правило {"match": "servers.*.cpu.*", "name": "cpu_$2",
+// "labels": {"host": "$1"}} переводит servers.web1.cpu.user в cpu_user{host=web1}.
+type GraphiteRule struct {
+	Match  string            `json:"match"`
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+func LoadGraphiteRules(path string) ([]GraphiteRule, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []GraphiteRule
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if rule.Match == "" || rule.Name == "" {
+			return nil, errors.New("graphite rule match and name must be set")
+		}
+	}
+	return rules, nil
+}
+
+// apply возвращает имя и метки для пути path, если он подходит под правило.
+func (rule *GraphiteRule) apply(path string) (string, map[string]string, bool) {
+	pattern := strings.Split(rule.Match, ".")
+	segments := strings.Split(path, ".")
+	if len(pattern) != len(segments) {
+		return "", nil, false
+	}
+	var captures []string
+	for i, p := range pattern {
+		if p == "*" {
+			captures = append(captures, segments[i])
+		} else if p != segments[i] {
+			return "", nil, false
+		}
+	}
+	// с конца, чтобы $1 не заменялся внутри $10
+	pairs := make([]string, 0, 2*len(captures))
+	for i := len(captures) - 1; i >= 0; i-- {
+		pairs = append(pairs, "$"+strconv.Itoa(i+1), captures[i])
+	}
+	replacer := strings.NewReplacer(pairs...)
+	var labels map[string]string
+	for k, v := range rule.Labels {
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[k] = replacer.Replace(v)
+	}
+	return replacer.Replace(rule.Name), labels, true
+}
+
+// mapGraphitePath применяет первое подходящее правило, без него путь
+// используется как имя метрики.
+func mapGraphitePath(rules []GraphiteRule, path string) (string, map[string]string) {
+	for i := range rules {
+		if name, labels, ok := rules[i].apply(path); ok {
+			return name, labels
+		}
+	}
+	return path, nil
+}
+
+// parseGraphiteLine разбирает строку "path value timestamp".
+func parseGraphiteLine(line string) (string, float64, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return "", 0, errors.New("expected path, value and timestamp")
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err = strconv.ParseFloat(fields[2], 64); err != nil {
+		return "", 0, errors.New("bad timestamp " + fields[2])
+	}
+	return fields[0], value, nil
+}
+
+// GraphiteListener принимает метрики в формате Graphite plaintext по TCP и
+// сохраняет их как gauge. Соединение с некорректной строкой закрывается, а
+// соединения с адресов вне доверенных подсетей закрываются сразу.
+type GraphiteListener struct {
+	storage  storage.Storage
+	listener net.Listener
+	rules    []GraphiteRule
+	trusted  []*net.IPNet
+	wg       sync.WaitGroup
+	// собственные метрики с момента последнего сохранения
+	accepted int64
+	rejected int64
+}
+
+func NewGraphiteListener(store storage.Storage, address string, rules []GraphiteRule, trusted []*net.IPNet) (*GraphiteListener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &GraphiteListener{storage: store, listener: listener, rules: rules, trusted: trusted}, nil
+}
+
+// Run принимает соединения, пока не завершится ctx.
+func (l *GraphiteListener) Run(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(graphiteStatsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.saveSelfMetrics(ctx)
+			case <-ctx.Done():
+				l.listener.Close()
+				return
+			}
+		}
+	}()
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				l.wg.Wait()
+				return
+			}
+			sugar.Errorw(err.Error(), "event", "accept graphite connection")
+			continue
+		}
+		if !ipTrusted(addrIP(conn.RemoteAddr()), l.trusted) {
+			sugar.Errorw("address is not in trusted subnet", "event", "accept graphite connection", "remote", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+		l.wg.Add(1)
+		go func() {
+			defer l.wg.Done()
+			l.handleConn(ctx, conn)
+		}()
+	}
+}
+
+func (l *GraphiteListener) handleConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	reader := bufio.NewReader(conn)
+	var batch []memstorage.Metrics
+	for {
+		conn.SetReadDeadline(time.Now().Add(graphiteIdleTimeout))
+		line, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			l.save(ctx, batch)
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line != "" {
+			path, value, err := parseGraphiteLine(line)
+			if err != nil {
+				atomic.AddInt64(&l.rejected, 1)
+				sugar.Errorw(err.Error(), "event", "parse graphite line", "remote", conn.RemoteAddr().String())
+				l.save(ctx, batch)
+				return
+			}
+			name, labels := mapGraphitePath(l.rules, path)
+			if code, _ := validateValues("gauge", name); code != http.StatusOK {
+				atomic.AddInt64(&l.rejected, 1)
+				sugar.Errorw("bad metric name "+name, "event", "parse graphite line", "remote", conn.RemoteAddr().String())
+				l.save(ctx, batch)
+				return
+			}
+			batch = append(batch, memstorage.Metrics{ID: name, MType: "gauge", Value: &value, Labels: labels})
+		}
+		// сохраняем, когда прочитано всё пришедшее или набрался пакет
+		if reader.Buffered() == 0 || len(batch) >= graphiteBatchSize {
+			l.save(ctx, batch)
+			batch = nil
+		}
+	}
+}
+
+func (l *GraphiteListener) save(ctx context.Context, batch []memstorage.Metrics) {
+	if len(batch) == 0 {
+		return
+	}
+	if _, err := l.storage.SaveBatch(ctx, batch); err != nil {
+		atomic.AddInt64(&l.rejected, int64(len(batch)))
+		sugar.Errorw(err.Error(), "event", "save graphite metrics")
+		return
+	}
+	atomic.AddInt64(&l.accepted, int64(len(batch)))
+}
+
+// saveSelfMetrics сохраняет счётчики принятых и отклонённых строк.
+func (l *GraphiteListener) saveSelfMetrics(ctx context.Context) {
+	var batch []memstorage.Metrics
+	if delta := atomic.SwapInt64(&l.accepted, 0); delta != 0 {
+		batch = append(batch, memstorage.Metrics{ID: "GraphiteLinesAccepted", MType: "counter", Delta: &delta})
+	}
+	if delta := atomic.SwapInt64(&l.rejected, 0); delta != 0 {
+		batch = append(batch, memstorage.Metrics{ID: "GraphiteLinesRejected", MType: "counter", Delta: &delta})
+	}
+	if len(batch) == 0 {
+		return
+	}
+	if _, err := l.storage.SaveBatch(ctx, batch); err != nil {
+		sugar.Errorw(err.Error(), "event", "save graphite self metrics")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+func Test_mapGraphitePath(t *testing.T) {
+	rules := []GraphiteRule{
+		{Match: "servers.*.cpu.*", Name: "cpu_$2", Labels: map[string]string{"host": "$1"}},
+		{Match: "servers.*.*", Name: "$2", Labels: map[string]string{"host": "$1", "source": "graphite"}},
+	}
+	tests := []struct {
+		name       string
+		path       string
+		wantName   string
+		wantLabels map[string]string
+	}{
+		{name: "Test1", path: "servers.web1.cpu.user", wantName: "cpu_user", wantLabels: map[string]string{"host": "web1"}},
+		{name: "Test2", path: "servers.db2.load", wantName: "load", wantLabels: map[string]string{"host": "db2", "source": "graphite"}},
+		{name: "Test3", path: "servers.web1.cpu.user.extra", wantName: "servers.web1.cpu.user.extra"},
+		{name: "Test4", path: "apps.web.requests", wantName: "apps.web.requests"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, labels := mapGraphitePath(rules, tt.path)
+			if name != tt.wantName || !reflect.DeepEqual(labels, tt.wantLabels) {
+				t.Errorf("mapGraphitePath() = %v %v, want %v %v", name, labels, tt.wantName, tt.wantLabels)
+			}
+		})
+	}
+}
+
+func Test_parseGraphiteLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		wantPath  string
+		wantValue float64
+		wantErr   bool
+	}{
+		{name: "Test1", line: "servers.web1.load 1.25 1690000000", wantPath: "servers.web1.load", wantValue: 1.25},
+		{name: "Test2", line: "servers.web1.load  -3  1690000000.5", wantPath: "servers.web1.load", wantValue: -3},
+		{name: "Test3", line: "servers.web1.load 1.25", wantErr: true},
+		{name: "Test4", line: "servers.web1.load high 1690000000", wantErr: true},
+		{name: "Test5", line: "servers.web1.load 1 now", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, value, err := parseGraphiteLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseGraphiteLine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if path != tt.wantPath || value != tt.wantValue {
+				t.Errorf("parseGraphiteLine() = %v %v, want %v %v", path, value, tt.wantPath, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestGraphiteListener(t *testing.T) {
+	mem := memstorage.NewMemStorage()
+	rules := []GraphiteRule{{Match: "servers.*.load", Name: "load", Labels: map[string]string{"host": "$1"}}}
+	l, err := NewGraphiteListener(storage.NewMemoryStorage(mem), "127.0.0.1:0", rules, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.Run(ctx)
+
+	conn, err := net.Dial("tcp", l.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("servers.web1.load 0.5 1690000000\nservers.web2.load 1.5 1690000000\nbroken line\nservers.web3.load 2 1690000000\n"))
+
+	// сервер закрывает соединение после некорректной строки
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Read() error = %v, want EOF", err)
+	}
+	_, m := mem.GetLabeledMetrics("gauge", "load", map[string]string{"host": "web2"})
+	if m == nil || *m.Value != 1.5 {
+		t.Errorf("load{host=web2} = %v, want 1.5", m)
+	}
+	if status, _ := mem.GetLabeledMetrics("gauge", "load", map[string]string{"host": "web3"}); status == http.StatusOK {
+		t.Error("line after malformed one should not be stored")
+	}
+	if got := atomic.LoadInt64(&l.accepted); got != 2 {
+		t.Errorf("accepted = %v, want 2", got)
+	}
+	if got := atomic.LoadInt64(&l.rejected); got != 1 {
+		t.Errorf("rejected = %v, want 1", got)
+	}
+}
+
+func TestGraphiteListener_untrusted(t *testing.T) {
+	subnets, err := parseSubnets("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := memstorage.NewMemStorage()
+	l, err := NewGraphiteListener(storage.NewMemoryStorage(mem), "127.0.0.1:0", nil, subnets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.Run(ctx)
+
+	conn, err := net.Dial("tcp", l.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("load 0.5 1690000000\n"))
+
+	// соединение с адреса вне доверенных подсетей закрывается без чтения
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); err == nil || ok && ne.Timeout() {
+		t.Fatalf("Read() error = %v, want closed connection", err)
+	}
+	if status, _ := mem.GetLabeledMetrics("gauge", "load", nil); status == http.StatusOK {
+		t.Error("metric from untrusted address should not be stored")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/metricspb"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// MetricsServer реализует gRPC API поверх того же хранилища, что и HTTP-обработчики.
+type MetricsServer struct {
+	metricspb.UnimplementedMetricsServer
+	storage storage.Storage
+}
+
+// grpcStatus переводит ошибку хранилища в статус gRPC
+func grpcStatus(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrHistoryDisabled):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, storage.ErrInvalidMetric):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func (s *MetricsServer) Update(ctx context.Context, req *metricspb.UpdateRequest) (*metricspb.UpdateResponse, error) {
+	if req.GetMetric() == nil {
+		return nil, status.Error(codes.InvalidArgument, "metric is required")
+	}
+	metric := req.GetMetric().ToMetrics()
+	if code, _ := validateValues(metric.MType, metric.ID); code != http.StatusOK {
+		return nil, status.Error(codes.InvalidArgument, "error validating type and name")
+	}
+	res, err := s.storage.Save(ctx, &metric)
+	if err != nil {
+		sugar.Errorln("storage.Save error: ", err.Error())
+		return nil, grpcStatus(err)
+	}
+	return &metricspb.UpdateResponse{Metric: metricspb.FromMetrics(*res)}, nil
+}
+
+func (s *MetricsServer) UpdateBatch(ctx context.Context, req *metricspb.UpdateBatchRequest) (*metricspb.UpdateBatchResponse, error) {
+	if len(req.GetMetrics()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "empty metrics batch")
+	}
+	metrics := make([]memstorage.Metrics, 0, len(req.GetMetrics()))
+	for _, m := range req.GetMetrics() {
+		metric := m.ToMetrics()
+		if code, _ := validateValues(metric.MType, metric.ID); code != http.StatusOK {
+			return nil, status.Error(codes.InvalidArgument, "error validating type and name of "+metric.ID)
+		}
+		metrics = append(metrics, metric)
+	}
+	res, err := s.storage.SaveBatch(ctx, metrics)
+	if err != nil {
+		sugar.Errorln("storage.SaveBatch error: ", err.Error())
+		return nil, grpcStatus(err)
+	}
+	return &metricspb.UpdateBatchResponse{Metrics: metricspb.FromMetricsSlice(res)}, nil
+}
+
+func (s *MetricsServer) Value(ctx context.Context, req *metricspb.ValueRequest) (*metricspb.ValueResponse, error) {
+	if code, _ := validateValues(req.GetType(), req.GetId()); code != http.StatusOK {
+		return nil, status.Error(codes.InvalidArgument, "error validating type and name")
+	}
+	res, err := s.storage.Get(ctx, req.GetType(), req.GetId(), req.GetLabels())
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	return &metricspb.ValueResponse{Metric: metricspb.FromMetrics(*res)}, nil
+}
+
+func (s *MetricsServer) List(ctx context.Context, req *metricspb.ListRequest) (*metricspb.ListResponse, error) {
+	res, err := s.storage.List(ctx)
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	return &metricspb.ListResponse{Metrics: metricspb.FromMetricsSlice(res)}, nil
+}
+
+func (s *MetricsServer) Ping(ctx context.Context, req *metricspb.PingRequest) (*metricspb.PingResponse, error) {
+	if err := s.storage.Ping(ctx); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &metricspb.PingResponse{}, nil
+}
+
+// LoggingInterceptor логирует вызовы так же, как LoggingMiddleware.
+func LoggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	sugar.Infoln(
+		"method", info.FullMethod,
+		"status", status.Code(err),
+		"duration", time.Since(start),
+	)
+	return resp, err
+}
+
+func updateMethod(fullMethod string) bool {
+	return fullMethod == metricspb.Metrics_Update_FullMethodName || fullMethod == metricspb.Metrics_UpdateBatch_FullMethodName
+}
+
+// TrustedSubnetInterceptor проверяет адрес агента из метаданных x-real-ip,
+// а без них - адрес соединения, для методов записи, как TrustedSubnetMiddleware.
+func TrustedSubnetInterceptor(subnets []*net.IPNet) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !updateMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		var realIP string
+		if values := metadata.ValueFromIncomingContext(ctx, metricspb.RealIPKey); len(values) > 0 {
+			realIP = values[0]
+		} else if p, ok := peer.FromContext(ctx); ok {
+			realIP = addrIP(p.Addr)
+		}
+		if !ipTrusted(realIP, subnets) {
+			return nil, status.Error(codes.PermissionDenied, "address is not in trusted subnet")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// ClientCertInterceptor при required требует для методов записи проверенный
+// сертификат клиента, как ClientCertMiddleware.
+func ClientCertInterceptor(required bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !required || !updateMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		if p, ok := peer.FromContext(ctx); ok {
+			if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.VerifiedChains) > 0 {
+				return handler(ctx, req)
+			}
+		}
+		return nil, status.Error(codes.PermissionDenied, "client certificate required")
+	}
+}
+
+// PlaintextUpdatesInterceptor при reject отклоняет методы записи. С -crypto-key
+// HTTP принимает только зашифрованные тела, а у gRPC шифрование даёт только
+// TLS, поэтому без TLS записи по gRPC запрещены.
+func PlaintextUpdatesInterceptor(reject bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if reject && updateMethod(info.FullMethod) {
+			return nil, status.Error(codes.FailedPrecondition, "updates must be encrypted, use TLS")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// SignInterceptor проверяет подпись запроса из метаданных hashsha256, если она
+// передана, и подписывает ответ, как checkSign и HTTP-обработчики.
+func SignInterceptor(key string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if key == "" {
+			return handler(ctx, req)
+		}
+		if values := metadata.ValueFromIncomingContext(ctx, metricspb.SignKey); len(values) > 0 {
+			sign, err := metricspb.Sign(req.(proto.Message), key)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			if sign != values[0] {
+				return nil, status.Error(codes.InvalidArgument, "sign hashes are not equal")
+			}
+		}
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		sign, err := metricspb.Sign(resp.(proto.Message), key)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		grpc.SetHeader(ctx, metadata.Pairs(metricspb.SignKey, sign))
+		return resp, nil
+	}
+}
+
+// newGRPCServer создаёт gRPC-сервер с теми же проверками, что и у HTTP-маршрутов.
+// tlsConfig == nil - без шифрования, encrypted - сервер принимает только
+// зашифрованные записи (задан -crypto-key).
+func newGRPCServer(store storage.Storage, key string, subnets []*net.IPNet, tlsConfig *tls.Config, encrypted bool) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(LoggingInterceptor, PlaintextUpdatesInterceptor(encrypted && tlsConfig == nil),
+			ClientCertInterceptor(tlsConfig != nil && tlsConfig.ClientCAs != nil), TrustedSubnetInterceptor(subnets), SignInterceptor(key)),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
+	metricspb.RegisterMetricsServer(server, &MetricsServer{storage: store})
+	return server
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/metricspb"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestMetricsServer(t *testing.T) {
+	const key = "secret"
+	mem := memstorage.NewMemStorage()
+	subnets, err := parseSubnets("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := bufconn.Listen(1 << 20)
+	server := newGRPCServer(storage.NewMemoryStorage(mem), key, subnets, nil, false)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := metricspb.NewMetricsClient(conn)
+
+	delta, value := int64(5), 1.5
+	batch := &metricspb.UpdateBatchRequest{Metrics: metricspb.FromMetricsSlice([]memstorage.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "gauge", Value: &value},
+	})}
+	sign, err := metricspb.Sign(batch, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		md       metadata.MD
+		req      *metricspb.UpdateBatchRequest
+		wantCode codes.Code
+	}{
+		{name: "Test1", md: metadata.Pairs(metricspb.RealIPKey, "10.1.2.3", metricspb.SignKey, sign), req: batch, wantCode: codes.OK},
+		{name: "Test2", md: metadata.Pairs(metricspb.RealIPKey, "10.1.2.3"), req: batch, wantCode: codes.OK},
+		{name: "Test3", md: metadata.Pairs(metricspb.RealIPKey, "192.168.0.1", metricspb.SignKey, sign), req: batch, wantCode: codes.PermissionDenied},
+		{name: "Test4", md: metadata.Pairs(metricspb.SignKey, sign), req: batch, wantCode: codes.PermissionDenied},
+		{name: "Test5", md: metadata.Pairs(metricspb.RealIPKey, "10.1.2.3", metricspb.SignKey, "bad"), req: batch, wantCode: codes.InvalidArgument},
+		{name: "Test6", md: metadata.Pairs(metricspb.RealIPKey, "10.1.2.3"), req: &metricspb.UpdateBatchRequest{}, wantCode: codes.InvalidArgument},
+		{name: "Test7", md: metadata.Pairs(metricspb.RealIPKey, "10.1.2.3"), req: &metricspb.UpdateBatchRequest{Metrics: metricspb.FromMetricsSlice([]memstorage.Metrics{
+			{ID: "123", MType: "gauge", Value: &value},
+		})}, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewOutgoingContext(context.Background(), tt.md)
+			var header metadata.MD
+			_, err := client.UpdateBatch(ctx, tt.req, grpc.Header(&header))
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("UpdateBatch() error = %v, want %v", err, tt.wantCode)
+			}
+			if tt.wantCode == codes.OK && len(header.Get(metricspb.SignKey)) == 0 {
+				t.Error("UpdateBatch() response is not signed")
+			}
+		})
+	}
+
+	// чтение доступно вне доверенной подсети
+	resp, err := client.Value(context.Background(), &metricspb.ValueRequest{Id: "PollCount", Type: "counter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetMetric().GetDelta() != 10 {
+		t.Errorf("Value() delta = %v, want 10", resp.GetMetric().GetDelta())
+	}
+	if _, err = client.Value(context.Background(), &metricspb.ValueRequest{Id: "123", Type: "gauge"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Value() with numeric name error = %v, want InvalidArgument", err)
+	}
+	if _, err = client.Value(context.Background(), &metricspb.ValueRequest{Id: "Missing", Type: "gauge"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Value() of missing metric error = %v, want NotFound", err)
+	}
+	list, err := client.List(context.Background(), &metricspb.ListRequest{})
+	if err != nil || len(list.GetMetrics()) != 2 {
+		t.Errorf("List() = %v, %v", list.GetMetrics(), err)
+	}
+	if _, err = client.Ping(context.Background(), &metricspb.PingRequest{}); err != nil {
+		t.Errorf("Ping() error = %v", err)
+	}
+}
+
+func TestUpdateInterceptors(t *testing.T) {
+	subnets, err := parseSubnets("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	update := &grpc.UnaryServerInfo{FullMethod: metricspb.Metrics_UpdateBatch_FullMethodName}
+	value := &grpc.UnaryServerInfo{FullMethod: metricspb.Metrics_Value_FullMethodName}
+	withPeer := func(ip string) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 5000}})
+	}
+	tests := []struct {
+		name        string
+		interceptor grpc.UnaryServerInterceptor
+		ctx         context.Context
+		info        *grpc.UnaryServerInfo
+		wantCode    codes.Code
+	}{
+		// без x-real-ip проверяется адрес соединения
+		{name: "Test1", interceptor: TrustedSubnetInterceptor(subnets), ctx: withPeer("10.1.2.3"), info: update, wantCode: codes.OK},
+		{name: "Test2", interceptor: TrustedSubnetInterceptor(subnets), ctx: withPeer("192.168.0.1"), info: update, wantCode: codes.PermissionDenied},
+		{name: "Test3", interceptor: TrustedSubnetInterceptor(subnets),
+			ctx: metadata.NewIncomingContext(withPeer("192.168.0.1"), metadata.Pairs(metricspb.RealIPKey, "10.1.2.3")), info: update, wantCode: codes.OK},
+		{name: "Test4", interceptor: PlaintextUpdatesInterceptor(true), ctx: context.Background(), info: update, wantCode: codes.FailedPrecondition},
+		{name: "Test5", interceptor: PlaintextUpdatesInterceptor(true), ctx: context.Background(), info: value, wantCode: codes.OK},
+		{name: "Test6", interceptor: PlaintextUpdatesInterceptor(false), ctx: context.Background(), info: update, wantCode: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.interceptor(tt.ctx, nil, tt.info, handler)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("interceptor error = %v, want %v", err, tt.wantCode)
+			}
+		})
+	}
+}
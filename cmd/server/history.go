@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// parseTimeParam понимает unix-время в секундах, RFC3339 и смещение
+// относительно now вида "-1h".
+func parseTimeParam(s string, def, now time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	if strings.HasPrefix(s, "-") {
+		dur, err := time.ParseDuration(s)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(dur), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseStepParam понимает длительность вида "30s" или число секунд.
+func parseStepParam(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if sec < 0 {
+			return 0, errors.New("negative step")
+		}
+		return time.Duration(sec) * time.Second, nil
+	}
+	step, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if step < 0 {
+		return 0, errors.New("negative step")
+	}
+	return step, nil
+}
+
+// parseLabelParams разбирает повторяющиеся параметры вида label=host=alpha.
+func parseLabelParams(params []string) (map[string]string, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, p := range params {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok || k == "" {
+			return nil, errors.New("bad label " + p)
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+func historyPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	handlerVars := r.Context().Value(HandlerVars{}).(*HandlerVars)
+	sugar.Infoln("historyPage")
+	mType := ps.ByName("mType")
+	mName := ps.ByName("mName")
+
+	statusRes, err := validateValues(mType, mName)
+	if err != nil {
+		http.Error(w, "Error validating type and name", statusRes)
+		return
+	}
+
+	query := r.URL.Query()
+	now := time.Now()
+	to, err := parseTimeParam(query.Get("to"), now, now)
+	if err != nil {
+		http.Error(w, "Error parsing to", http.StatusBadRequest)
+		return
+	}
+	from, err := parseTimeParam(query.Get("from"), to.Add(-time.Hour), now)
+	if err != nil {
+		http.Error(w, "Error parsing from", http.StatusBadRequest)
+		return
+	}
+	step, err := parseStepParam(query.Get("step"))
+	if err != nil {
+		http.Error(w, "Error parsing step", http.StatusBadRequest)
+		return
+	}
+
+	labels, err := parseLabelParams(query["label"])
+	if err != nil {
+		http.Error(w, "Error parsing labels", http.StatusBadRequest)
+		return
+	}
+
+	samples, err := handlerVars.storage.History(r.Context(), mType, mName, labels, from, to, step)
+	if err != nil {
+		http.Error(w, "Error getting history", storageStatus(err))
+		return
+	}
+	respJSON, err := json.Marshal(samples)
+	if err != nil {
+		http.Error(w, "json.Marshal failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respJSON)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+func Test_parseTimeParam(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		s       string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "Test1", s: "", want: now},
+		{name: "Test2", s: "1672574400", want: time.Unix(1672574400, 0)},
+		{name: "Test3", s: "-1h", want: now.Add(-time.Hour)},
+		{name: "Test4", s: "2023-01-01T11:30:00Z", want: now.Add(-30 * time.Minute)},
+		{name: "Test5", s: "yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTimeParam(tt.s, now, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseTimeParam() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !got.Equal(tt.want) {
+				t.Errorf("parseTimeParam() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_historyPage(t *testing.T) {
+	mem := memstorage.NewMemStorage()
+	mem.History = memstorage.NewHistory(time.Hour, 10, 1<<10)
+	mem.PutGauge("HeapAlloc", 1)
+	mem.PutGauge("HeapAlloc", 2)
+	handlerVars := &HandlerVars{storage: storage.NewMemoryStorage(mem)}
+
+	router := httprouter.New()
+	router.GET("/history/:mType/:mName", ParamsMiddleware(historyPage, handlerVars))
+
+	tests := []struct {
+		name    string
+		url     string
+		status  int
+		samples int
+	}{
+		{name: "Test1", url: "/history/gauge/HeapAlloc", status: http.StatusOK, samples: 2},
+		{name: "Test2", url: "/history/gauge/HeapAlloc?from=-1m&step=1h", status: http.StatusOK, samples: 1},
+		{name: "Test3", url: "/history/gauge/Missing", status: http.StatusNotFound},
+		{name: "Test4", url: "/history/gauge/HeapAlloc?step=often", status: http.StatusBadRequest},
+		{name: "Test5", url: "/history/gauge/HeapAlloc?step=-60", status: http.StatusBadRequest},
+		{name: "Test6", url: "/history/gauge/HeapAlloc?step=-1m", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != tt.status {
+				t.Fatalf("historyPage() status = %v, want %v", w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var samples []memstorage.Sample
+			if err := json.Unmarshal(w.Body.Bytes(), &samples); err != nil {
+				t.Fatal(err)
+			}
+			if len(samples) != tt.samples {
+				t.Errorf("historyPage() returned %d samples, want %d", len(samples), tt.samples)
+			}
+		})
+	}
+}
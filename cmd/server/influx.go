@@ -0,0 +1,198 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+)
+
+// splitInflux делит s по разделителю sep, пропуская экранированные обратной
+// косой чертой символы и, если quotes, строки в двойных кавычках.
+func splitInflux(s string, sep byte, quotes bool) []string {
+	var parts []string
+	start, inQuote := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quotes && s[i] == '"':
+			inQuote = !inQuote
+		case s[i] == sep && !inQuote:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+var influxUnescaper = strings.NewReplacer(`\,`, ",", `\=`, "=", `\ `, " ", `\"`, `"`, `\\`, `\`)
+
+// splitInfluxPair делит key=value по первому неэкранированному знаку равенства.
+func splitInfluxPair(s string) (string, string, error) {
+	parts := splitInflux(s, '=', true)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.New("bad key=value pair " + s)
+	}
+	return influxUnescaper.Replace(parts[0]), parts[1], nil
+}
+
+// parseInfluxLine разбирает строку line protocol
+// measurement[,tag=value...] field=value[,field=value...] [timestamp].
+// Каждое поле становится метрикой measurement_field с тегами в качестве меток:
+// целые поля (1i, 1u) - счётчиками, в Delta которых пока лежит накопленное
+// значение, дробные и логические - gauge. Строковые поля пропускаются.
+func parseInfluxLine(line string) ([]memstorage.Metrics, error) {
+	var sections []string
+	for _, s := range splitInflux(line, ' ', true) {
+		if s != "" {
+			sections = append(sections, s)
+		}
+	}
+	if len(sections) != 2 && len(sections) != 3 {
+		return nil, errors.New("expected measurement, fields and optional timestamp")
+	}
+	if len(sections) == 3 {
+		if _, err := strconv.ParseInt(sections[2], 10, 64); err != nil {
+			return nil, errors.New("bad timestamp " + sections[2])
+		}
+	}
+
+	key := splitInflux(sections[0], ',', false)
+	measurement := influxUnescaper.Replace(key[0])
+	if measurement == "" {
+		return nil, errors.New("empty measurement")
+	}
+	var labels map[string]string
+	for _, tag := range key[1:] {
+		k, v, err := splitInfluxPair(tag)
+		if err != nil {
+			return nil, err
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[k] = influxUnescaper.Replace(v)
+	}
+
+	var metrics []memstorage.Metrics
+	for _, field := range splitInflux(sections[1], ',', true) {
+		k, v, err := splitInfluxPair(field)
+		if err != nil {
+			return nil, err
+		}
+		metric := memstorage.Metrics{ID: measurement + "_" + k, Labels: labels}
+		switch {
+		case strings.HasPrefix(v, `"`):
+			continue
+		case strings.HasSuffix(v, "i"):
+			delta, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			metric.MType = "counter"
+			metric.Delta = &delta
+		case strings.HasSuffix(v, "u"):
+			u, err := strconv.ParseUint(v[:len(v)-1], 10, 63)
+			if err != nil {
+				return nil, err
+			}
+			delta := int64(u)
+			metric.MType = "counter"
+			metric.Delta = &delta
+		default:
+			var value float64
+			switch v {
+			case "t", "T", "true", "True", "TRUE":
+				value = 1
+			case "f", "F", "false", "False", "FALSE":
+				value = 0
+			default:
+				value, err = strconv.ParseFloat(v, 64)
+				if err != nil {
+					return nil, err
+				}
+			}
+			metric.MType = "gauge"
+			metric.Value = &value
+		}
+		if code, _ := validateValues(metric.MType, metric.ID); code != http.StatusOK {
+			return nil, errors.New("bad metric name " + metric.ID)
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// influxWritePage принимает метрики в формате InfluxDB line protocol,
+// как /write у InfluxDB 1.x, и сохраняет их одним пакетом. Целые поля Telegraf
+// присылает накопленными итогами, поэтому в счётчики они попадают приращениями
+// через свой CumulativeTracker, как cumulative-ряды OTLP: первое значение ряда
+// только запоминается, уменьшение считается сбросом.
+func influxWritePage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	handlerVars := r.Context().Value(HandlerVars{}).(*HandlerVars)
+	sugar.Infoln("influxWritePage")
+
+	reqBody := r.Body
+	if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
+		var err error
+		reqBody, err = gzip.NewReader(reqBody)
+		if err != nil {
+			http.Error(w, "gzip.NewReader failed", http.StatusBadRequest)
+			return
+		}
+	}
+
+	bodyBytes, err := io.ReadAll(reqBody)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	if checkSign(r, bodyBytes, handlerVars) != http.StatusOK {
+		http.Error(w, "Sign hashes are not equal", http.StatusBadRequest)
+		return
+	}
+
+	var batch []memstorage.Metrics
+	for i, line := range strings.Split(string(bodyBytes), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		metrics, err := parseInfluxLine(line)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("line %d: %s", i+1, err.Error()), http.StatusBadRequest)
+			return
+		}
+		batch = append(batch, metrics...)
+	}
+	tracker := handlerVars.influx
+	tracker.mu.Lock()
+	cumulative := &otlpBatch{points: make(map[string]cumulativePoint), now: time.Now()}
+	for i, m := range batch {
+		if m.MType == "counter" {
+			delta := tracker.sumDelta(cumulative, memstorage.SeriesKey(m.ID, m.Labels), 0, float64(*m.Delta))
+			batch[i].Delta = &delta
+		}
+	}
+	tracker.commit(cumulative)
+	tracker.mu.Unlock()
+	if len(batch) != 0 {
+		_, err = handlerVars.storage.SaveBatch(r.Context(), batch)
+		if err != nil {
+			tracker.rollback(cumulative)
+			sugar.Errorln("storage.SaveBatch error: ", err.Error())
+			http.Error(w, "storage.SaveBatch failed", storageStatus(err))
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
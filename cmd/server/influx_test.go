@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+func Test_parseInfluxLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    []string
+		labels  map[string]string
+		wantErr bool
+	}{
+		{name: "Test1", line: "cpu,host=a,dc=eu usage=0.5,ticks=12i 1690000000000000000",
+			want: []string{"gauge cpu_usage 0.5", "counter cpu_ticks 12"}, labels: map[string]string{"host": "a", "dc": "eu"}},
+		{name: "Test2", line: "mem free=1024u,ok=true", want: []string{"counter mem_free 1024", "gauge mem_ok 1"}},
+		{name: "Test3", line: `disk,path=/var\ log used=3,label="a, b=c" `, want: []string{"gauge disk_used 3"}, labels: map[string]string{"path": "/var log"}},
+		{name: "Test4", line: `my\,app requests=1i`, want: []string{"counter my,app_requests 1"}},
+		{name: "Test5", line: "cpu", wantErr: true},
+		{name: "Test6", line: "cpu usage=high", wantErr: true},
+		{name: "Test7", line: "cpu usage=1 yesterday", wantErr: true},
+		{name: "Test8", line: "cpu,host usage=1", wantErr: true},
+		{name: "Test9", line: "cpu usage=1.5i", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseInfluxLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseInfluxLine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseInfluxLine() returned %d metrics, want %d", len(got), len(tt.want))
+			}
+			for i, m := range got {
+				s := fmt.Sprint(m.MType, " ", m.ID, " ")
+				if m.Delta != nil {
+					s += fmt.Sprint(*m.Delta)
+				} else {
+					s += fmt.Sprint(*m.Value)
+				}
+				if s != tt.want[i] {
+					t.Errorf("metric %d = %s, want %s", i, s, tt.want[i])
+				}
+				if len(m.Labels) != len(tt.labels) {
+					t.Errorf("metric %d labels = %v, want %v", i, m.Labels, tt.labels)
+				}
+				for k, v := range tt.labels {
+					if m.Labels[k] != v {
+						t.Errorf("metric %d labels = %v, want %v", i, m.Labels, tt.labels)
+					}
+				}
+			}
+		})
+	}
+}
+
+func Test_influxWritePage(t *testing.T) {
+	mem := memstorage.NewMemStorage()
+	handlerVars := &HandlerVars{storage: storage.NewMemoryStorage(mem), key: new(string), influx: NewCumulativeTracker(time.Now())}
+	router := httprouter.New()
+	router.POST("/write", ParamsMiddleware(influxWritePage, handlerVars))
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   int64
+	}{
+		// целые поля - накопленные итоги: первое значение только запоминается
+		{name: "Test1", body: "# telegraf\nnet packets=3i\n\nnet packets=4i,speed=1.5\n", status: http.StatusNoContent, want: 1},
+		{name: "Test2", body: "net packets=5i\nnet packets=oops\n", status: http.StatusBadRequest, want: 1},
+		{name: "Test3", body: "", status: http.StatusNoContent, want: 1},
+		// то же накопленное значение ещё раз не меняет счётчик
+		{name: "Test4", body: "net packets=4i\n", status: http.StatusNoContent, want: 1},
+		{name: "Test5", body: "net packets=4i\n", status: http.StatusNoContent, want: 1},
+		{name: "Test6", body: "net packets=10u\n", status: http.StatusNoContent, want: 7},
+		// уменьшение - сброс у клиента, новое значение учитывается целиком
+		{name: "Test7", body: "net packets=2i\n", status: http.StatusNoContent, want: 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/write?db=telegraf", strings.NewReader(tt.body)))
+			if w.Code != tt.status {
+				t.Fatalf("influxWritePage() status = %v, want %v: %s", w.Code, tt.status, w.Body.String())
+			}
+			// при ошибке в одной строке пакет не сохраняется целиком
+			if got, _ := mem.GetCounter("net_packets"); got != tt.want {
+				t.Errorf("net_packets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,70 +2,34 @@ package main
 
 import (
 	"context"
+	"crypto/rsa"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
-	"sync"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/jackc/pgerrcode"
-	"github.com/jackc/pgx"
 	"github.com/julienschmidt/httprouter"
-	"github.com/kishenkoilya/metricsalerts/internal/filerw"
+	"github.com/kishenkoilya/metricsalerts/internal/envelope"
 	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
 	"github.com/kishenkoilya/metricsalerts/internal/psqlinteraction"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+	"github.com/kishenkoilya/metricsalerts/internal/tlsconfig"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
 var sugar zap.SugaredLogger
 
-func Retrypg(errClass string, f psqlinteraction.RetryFunc) (interface{}, error) {
-	var result interface{}
-	var err error
-
-	for i := 0; i < 3; i++ {
-		result, err = f()
-		if err == nil {
-			return result, nil
-		} else {
-			if pgerr, ok := err.(pgx.PgError); ok {
-				if errCodeCompare(errClass, pgerr.Code) {
-					switch i {
-					case 0:
-						time.Sleep(1 * time.Second)
-					case 1:
-						time.Sleep(3 * time.Second)
-					case 2:
-						time.Sleep(5 * time.Second)
-					default:
-						return nil, err
-					}
-				}
-			}
-		}
-	}
-
-	return nil, fmt.Errorf("all %d attempts failed: %w", 3, err)
-}
-
-func errCodeCompare(errClass, errCode string) bool {
-	switch errClass {
-	case pgerrcode.ConnectionException:
-		return pgerrcode.IsConnectionException(errCode)
-	case pgerrcode.OperatorIntervention:
-		return pgerrcode.IsOperatorIntervention(errCode)
-	default:
-		return false
-	}
-}
-
 func validateValues(mType, mName string) (int, error) {
-	if mType != "counter" && mType != "gauge" {
-		return http.StatusBadRequest, errors.New("metric type not counter, nor gauge")
+	if mType != "counter" && mType != "gauge" && mType != "histogram" {
+		return http.StatusBadRequest, errors.New("metric type not counter, gauge, nor histogram")
 	}
 	_, err := strconv.ParseInt(mName, 0, 64)
 	if err == nil {
@@ -79,63 +43,55 @@ func validateValues(mType, mName string) (int, error) {
 	return http.StatusOK, nil
 }
 
-func writeValue(handlerVars *HandlerVars, mType, mName, mVal string) int {
-	if handlerVars.db != nil {
-		sugar.Infoln("Writing metric to db")
-		dbWriteMetricFunc := handlerVars.db.WriteMetric(mType, mName, mVal)
-		_, err := Retrypg(pgerrcode.ConnectionException, dbWriteMetricFunc)
-		if err != nil {
-			fmt.Println(err.Error())
-			return http.StatusInternalServerError
-		}
-	} else if handlerVars.syncFileWriter != nil {
-		sugar.Infoln("Writing metric to file")
-		err := handlerVars.syncFileWriter.WriteMetric(&filerw.Metric{ID: mName, MType: mType, MVal: mVal})
-		if err != nil {
-			fmt.Println(err.Error())
-			return http.StatusInternalServerError
-		}
+// storageStatus переводит ошибку хранилища в HTTP-статус
+func storageStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrHistoryDisabled):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrInvalidMetric):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
 	}
-	return http.StatusOK
 }
 
-func writeValues(handlerVars *HandlerVars, metrics *[]memstorage.Metrics) int {
-	if handlerVars.db != nil {
-		sugar.Infoln("Writing metrics to db")
-		dbWriteMetricsFunc := handlerVars.db.WriteMetrics(metrics)
-		_, err := Retrypg(pgerrcode.ConnectionException, dbWriteMetricsFunc)
-		if err != nil {
-			fmt.Println(err.Error())
-			return http.StatusInternalServerError
-		}
-	} else if handlerVars.syncFileWriter != nil {
-		sugar.Infoln("Writing metric to file")
-		err := handlerVars.syncFileWriter.WriteMetrics(metrics)
+func getValue(ctx context.Context, store storage.Storage, mType, mName string) (int, string) {
+	metric, err := store.Get(ctx, mType, mName, nil)
+	if err != nil {
+		return storageStatus(err), ""
+	}
+	if metric.Histogram != nil {
+		res, err := json.Marshal(metric.Histogram)
 		if err != nil {
-			fmt.Println(err.Error())
-			return http.StatusInternalServerError
+			return http.StatusInternalServerError, ""
 		}
+		return http.StatusOK, string(res)
 	}
-	return http.StatusOK
+	if metric.Delta != nil {
+		return http.StatusOK, fmt.Sprint(*metric.Delta)
+	}
+	return http.StatusOK, fmt.Sprint(*metric.Value)
 }
 
-func getValue(storage *memstorage.MemStorage, mType, mName string) (int, string) {
-	var res string
-	status := http.StatusOK
-	if mType == "gauge" {
-		gauge, ok := storage.GetGauge(mName)
-		if !ok {
-			return http.StatusNotFound, ""
-		}
-		res = fmt.Sprint(gauge)
-	} else {
-		counter, ok := storage.GetCounter(mName)
-		if !ok {
-			return http.StatusNotFound, ""
+// newStorage выбирает хранилище: Postgres, если задан DSN и к нему удалось
+// подключиться, иначе файл, иначе только память.
+func newStorage(config *Config, mem *memstorage.MemStorage) (storage.Storage, error) {
+	syncWrite := config.StoreInterval == 0
+	if config.DatabaseDSN != "" {
+		store, err := storage.NewDBStorage(mem, config.DatabaseDSN, syncWrite, time.Duration(config.DBSamplesRetention)*time.Second, psqlinteraction.PoolConfig{
+			MaxConns:               int32(config.DBMaxConns),
+			MinConns:               int32(config.DBMinConns),
+			StatementCacheCapacity: config.DBStatementCache,
+		})
+		if err == nil {
+			return store, nil
 		}
-		res = fmt.Sprint(counter)
+		sugar.Errorw(err.Error(), "event", "init DB storage")
 	}
-	return status, res
+	if config.FilePath != "" {
+		return storage.NewFileStorage(mem, config.FilePath, syncWrite)
+	}
+	return storage.NewMemoryStorage(mem), nil
 }
 
 func main() {
@@ -149,109 +105,163 @@ func main() {
 	sugar = *logger.Sugar()
 
 	config := getVars()
-	storage := memstorage.NewMemStorage()
-	dbConnFunc := psqlinteraction.NewDBConnection((*config).DatabaseDSN)
+	mem := memstorage.NewMemStorage()
+	mem.Broker = memstorage.NewBroker(streamBuffer)
+	if (*config).HistoryRetention != 0 {
+		mem.History = memstorage.NewHistory(time.Duration((*config).HistoryRetention)*time.Second,
+			(*config).HistorySamples, (*config).HistoryMemoryLimit)
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					mem.History.Prune()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	store, err := newStorage(config, mem)
+	if err != nil {
+		sugar.Fatalw(err.Error(), "event", "init storage")
+	}
 	if (*config).Restore {
-		var obj interface{}
-		var err error
-		if (*config).DatabaseDSN != "" {
-			obj, err = Retrypg(pgerrcode.OperatorIntervention, dbConnFunc)
-		} else {
-			err = errors.New("not nil")
-		}
+		err = store.Restore(ctx)
 		if err != nil {
-			fmt.Println(err.Error())
-			consumer, err := filerw.NewConsumer((*config).FilePath)
-			if err == nil {
-				storage, _ = consumer.ReadMemStorage()
-				fmt.Println(storage.PrintAll())
-			}
+			sugar.Errorw(err.Error(), "event", "restore metrics")
 		} else {
-			var db *psqlinteraction.DBConnection
-			if obj != nil {
-				db = obj.(*psqlinteraction.DBConnection)
-			}
-			dbReadMemFunc := db.ReadMemStorage()
-			obj, err := Retrypg(pgerrcode.ConnectionException, dbReadMemFunc)
-			if obj != nil {
-				storage = obj.(*memstorage.MemStorage)
-			}
-			if err == nil {
-				fmt.Println(storage.PrintAll())
-			} else {
-				consumer, err := filerw.NewConsumer((*config).FilePath)
-				if err == nil {
-					storage, _ = consumer.ReadMemStorage()
-					fmt.Println(storage.PrintAll())
-				}
-			}
+			fmt.Println(mem.PrintAll())
 		}
 	}
 
-	// psqlLine = "host=localhost port=5432 user=postgres password=gpadmin dbname=postgres"
-	var handlerVars *HandlerVars
-	syncFileWriter, err := filerw.NewProducer((*config).FilePath, false)
+	rules, err := LoadAlertRules((*config).AlertRules)
 	if err != nil {
-		sugar.Fatalw(err.Error(), "event", "Init file writer")
+		sugar.Fatalw(err.Error(), "event", "load alert rules")
 	}
-	if (*config).StoreInterval != 0 {
-		syncFileWriter = nil
+	alerts, err := NewAlertEngine(mem, rules)
+	if err != nil {
+		sugar.Fatalw(err.Error(), "event", "init alert engine")
 	}
-	obj, err := Retrypg(pgerrcode.OperatorIntervention, dbConnFunc)
-	var db *psqlinteraction.DBConnection
-	if obj != nil {
-		db = obj.(*psqlinteraction.DBConnection)
+	var notifier *WebhookNotifier
+	if (*config).WebhookURLs != "" {
+		notifier = NewWebhookNotifier(strings.Split((*config).WebhookURLs, ","), (*config).Key,
+			time.Duration((*config).WebhookGroupInterval)*time.Second)
+		go notifier.Run(ctx)
 	}
-	if err != nil || (*config).StoreInterval != 0 {
-		handlerVars = &HandlerVars{
-			storage:         storage,
-			syncFileWriter:  syncFileWriter,
-			psqlConnectLine: &(*config).DatabaseDSN,
-			db:              nil,
-			key:             &(*config).Key,
-		}
-	} else {
-		handlerVars = &HandlerVars{
-			storage:         storage,
-			syncFileWriter:  syncFileWriter,
-			psqlConnectLine: &(*config).DatabaseDSN,
-			db:              db,
-			key:             &(*config).Key,
-		}
+	go alerts.Run(ctx, time.Duration((*config).AlertInterval)*time.Second, notifier)
+
+	handlerVars := &HandlerVars{
+		storage: store,
+		key:     &(*config).Key,
+		alerts:  alerts,
+		otlp:    NewCumulativeTracker(time.Now()),
+		influx:  NewCumulativeTracker(time.Now()),
+		broker:  mem.Broker,
 	}
-	if db != nil {
-		dbInitFunc := db.InitTables()
-		_, err := Retrypg(pgerrcode.ConnectionException, dbInitFunc)
+
+	var cryptoKey *rsa.PrivateKey
+	if (*config).CryptoKey != "" {
+		cryptoKey, err = envelope.LoadPrivateKey((*config).CryptoKey)
 		if err != nil {
-			sugar.Fatalw(err.Error(), "event", "init DB")
+			sugar.Fatalw(err.Error(), "event", "load crypto key")
 		}
 	}
+	trusted, err := parseSubnets((*config).TrustedSubnet)
+	if err != nil {
+		sugar.Fatalw(err.Error(), "event", "parse trusted subnet")
+	}
+
+	// с -tls-client-ca сертификат агента обязателен для маршрутов записи
+	requireClientCert := (*config).TLSClientCA != ""
 
 	router := httprouter.New()
-	router.GET("/", LoggingMiddleware(GzipMiddleware(ParamsMiddleware(printAllPage, handlerVars))))
+	router.GET("/", LoggingMiddleware(GzipMiddleware(ParamsMiddleware(dashboardPage, handlerVars))))
+	router.GET("/text", LoggingMiddleware(GzipMiddleware(ParamsMiddleware(printAllPage, handlerVars))))
 	router.GET("/value/:mType/:mName", LoggingMiddleware(GzipMiddleware(ParamsMiddleware(getPage, handlerVars))))
 	router.GET("/ping", LoggingMiddleware(GzipMiddleware(ParamsMiddleware(pingPostgrePage, handlerVars))))
-	router.POST("/update/:mType/:mName/:mVal", LoggingMiddleware(GzipMiddleware(ParamsMiddleware(updatePage, handlerVars))))
+	router.GET("/history/:mType/:mName", LoggingMiddleware(GzipMiddleware(ParamsMiddleware(historyPage, handlerVars))))
+	router.GET("/api/metrics", LoggingMiddleware(GzipMiddleware(ParamsMiddleware(apiMetricsPage, handlerVars))))
+	router.GET("/metrics", LoggingMiddleware(GzipMiddleware(ParamsMiddleware(prometheusPage, handlerVars))))
+	router.GET("/alerts", LoggingMiddleware(GzipMiddleware(ParamsMiddleware(alertsPage, handlerVars))))
+	router.GET("/stream", LoggingMiddleware(ParamsMiddleware(streamPage, handlerVars)))
+	// с -crypto-key значения в адресе не шифруются, поэтому такой запрос отклоняется
+	router.POST("/update/:mType/:mName/:mVal", LoggingMiddleware(ClientCertMiddleware(TrustedSubnetMiddleware(GzipMiddleware(DecryptMiddleware(ParamsMiddleware(updatePage, handlerVars), cryptoKey)), trusted), requireClientCert)))
 	router.POST("/value/", LoggingMiddleware(GzipMiddleware(ParamsMiddleware(getJSONPage, handlerVars))))
-	router.POST("/update/", LoggingMiddleware(GzipMiddleware(ParamsMiddleware(updateJSONPage, handlerVars))))
-	router.POST("/updates/", LoggingMiddleware(GzipMiddleware(ParamsMiddleware(massUpdatePage, handlerVars))))
+	router.POST("/update/", LoggingMiddleware(ClientCertMiddleware(TrustedSubnetMiddleware(GzipMiddleware(DecryptMiddleware(ParamsMiddleware(updateJSONPage, handlerVars), cryptoKey)), trusted), requireClientCert)))
+	router.POST("/updates/", LoggingMiddleware(ClientCertMiddleware(TrustedSubnetMiddleware(GzipMiddleware(DecryptMiddleware(ParamsMiddleware(massUpdatePage, handlerVars), cryptoKey)), trusted), requireClientCert)))
+	// Telegraf и коллекторы OpenTelemetry не умеют шифровать тело ключом сервера,
+	// поэтому, как StatsD и Graphite, эти маршруты защищены только TLS и подсетями
+	router.POST("/write", LoggingMiddleware(ClientCertMiddleware(TrustedSubnetMiddleware(GzipMiddleware(ParamsMiddleware(influxWritePage, handlerVars)), trusted), requireClientCert)))
+	router.POST("/v1/metrics", LoggingMiddleware(ClientCertMiddleware(TrustedSubnetMiddleware(GzipMiddleware(ParamsMiddleware(otlpMetricsPage, handlerVars)), trusted), requireClientCert)))
+	router.POST("/query", LoggingMiddleware(GzipMiddleware(ParamsMiddleware(queryPage, handlerVars))))
 
+	// при остановке сервера отменяются контексты запросов, иначе Shutdown
+	// ждал бы завершения открытых /stream
+	baseCtx, cancelRequests := context.WithCancel(ctx)
 	server := &http.Server{
-		Addr:    (*config).Address,
-		Handler: router,
+		Addr:        (*config).Address,
+		Handler:     router,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
 	}
-	go func() {
-		err = server.ListenAndServe()
+	server.RegisterOnShutdown(cancelRequests)
+	if config.TLSCert != "" {
+		server.TLSConfig, err = tlsconfig.Server(config.TLSCert, config.TLSKey, config.TLSClientCA)
 		if err != nil {
+			sugar.Fatalw(err.Error(), "event", "load TLS config")
+		}
+	}
+	go func() {
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			sugar.Fatalw(err.Error(), "event", "start server")
 		}
 	}()
 
+	var grpcServer *grpc.Server
+	if (*config).GRPCAddress != "" {
+		listener, err := net.Listen("tcp", (*config).GRPCAddress)
+		if err != nil {
+			sugar.Fatalw(err.Error(), "event", "start gRPC server")
+		}
+		if cryptoKey != nil && server.TLSConfig == nil {
+			sugar.Warnln("-crypto-key is set without TLS, gRPC updates will be rejected")
+		}
+		grpcServer = newGRPCServer(store, (*config).Key, trusted, server.TLSConfig, cryptoKey != nil)
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				sugar.Fatalw(err.Error(), "event", "start gRPC server")
+			}
+		}()
+	}
+
+	if (*config).StatsDAddress != "" {
+		statsd, err := NewStatsDListener(store, (*config).StatsDAddress, trusted)
+		if err != nil {
+			sugar.Fatalw(err.Error(), "event", "start statsd listener")
+		}
+		go statsd.Run(ctx)
+	}
+	if (*config).GraphiteAddress != "" {
+		rules, err := LoadGraphiteRules((*config).GraphiteRules)
+		if err != nil {
+			sugar.Fatalw(err.Error(), "event", "load graphite rules")
+		}
+		graphite, err := NewGraphiteListener(store, (*config).GraphiteAddress, rules, trusted)
+		if err != nil {
+			sugar.Fatalw(err.Error(), "event", "start graphite listener")
+		}
+		go graphite.Run(ctx)
+	}
+
 	if (*config).StoreInterval != 0 {
-		var wg sync.WaitGroup
-		wg.Add(10)
 		go func() {
-			defer wg.Done()
 			ticker := time.NewTicker(time.Duration((*config).StoreInterval) * time.Second)
 			defer ticker.Stop()
 
@@ -259,68 +269,39 @@ func main() {
 				select {
 				case <-ticker.C:
 					fmt.Println("Saving to storage")
-					obj, err := Retrypg(pgerrcode.OperatorIntervention, dbConnFunc)
-					var db *psqlinteraction.DBConnection
-					if obj != nil {
-						db = obj.(*psqlinteraction.DBConnection)
-					}
+					err := store.Snapshot(ctx)
 					if err != nil {
-						producer, err := filerw.NewProducer((*config).FilePath, true)
-						if err != nil {
-							sugar.Fatalw(err.Error(), "event", "init file writer")
-						}
-						err = producer.WriteMemStorage(storage)
-						if err != nil {
-							panic(err)
-						}
-					} else {
-						dbWriteMemFunc := db.WriteMemStorage(storage)
-						_, err = Retrypg(pgerrcode.ConnectionException, dbWriteMemFunc)
-						if err != nil {
-							panic(err)
-						}
+						sugar.Errorw(err.Error(), "event", "snapshot storage")
 					}
 				case <-ctx.Done():
 					return
 				}
 			}
 		}()
-		wg.Wait()
 	}
-	waitForShutdown(server, handlerVars, (*config).FilePath)
+	waitForShutdown(server, grpcServer, store)
 	fmt.Println("Programm shutdown")
 }
 
-func waitForShutdown(server *http.Server, handlerVars *HandlerVars, filePath string) {
+func waitForShutdown(server *http.Server, grpcServer *grpc.Server, store storage.Storage) {
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 
 	<-signalChan
-	dbConnFunc := psqlinteraction.NewDBConnection(*handlerVars.psqlConnectLine)
-	obj, err := Retrypg(pgerrcode.OperatorIntervention, dbConnFunc)
-	var db *psqlinteraction.DBConnection
-	if obj != nil {
-		db = obj.(*psqlinteraction.DBConnection)
+	err := server.Shutdown(context.TODO())
+	if err != nil {
+		sugar.Errorf("Error while stopping HTTP-server: %v\n", err)
 	}
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	err = store.Snapshot(context.TODO())
 	if err != nil {
-		producer, err := filerw.NewProducer(filePath, true)
-		if err != nil {
-			sugar.Fatalw(err.Error(), "event", "init file writer")
-		}
-		err = server.Shutdown(context.TODO())
-		if err != nil {
-			sugar.Errorf("Error while stopping HTTP-server: %v\n", err)
-		}
-		err = producer.WriteMemStorage(handlerVars.storage)
-		if err != nil {
-			sugar.Errorln(err.Error())
-		}
-	} else {
-		dbWriteMemFunc := db.WriteMemStorage(handlerVars.storage)
-		_, err = Retrypg(pgerrcode.ConnectionException, dbWriteMemFunc)
-		if err != nil {
-			panic(err)
-		}
+		sugar.Errorln(err.Error())
+	}
+	err = store.Close()
+	if err != nil {
+		sugar.Errorln(err.Error())
 	}
 	fmt.Println("HTTP-server shutdown.")
 }
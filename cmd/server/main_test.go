@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"os"
 	"sync"
 	"testing"
 
 	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+	"go.uber.org/zap"
 )
 
+func TestMain(m *testing.M) {
+	sugar = *zap.NewNop().Sugar()
+	os.Exit(m.Run())
+}
+
 func Test_validateValues(t *testing.T) {
 	type args struct {
 		mType string
@@ -58,6 +67,14 @@ func Test_validateValues(t *testing.T) {
 			},
 			want: http.StatusBadRequest,
 		},
+		{
+			name: "Test6",
+			args: args{
+				mType: "histogram",
+				mName: "SendLatency",
+			},
+			want: http.StatusOK,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -139,7 +156,7 @@ func Test_getValue(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1 := getValue(tt.args.storage, tt.args.mType, tt.args.mName)
+			got, got1 := getValue(context.Background(), storage.NewMemoryStorage(tt.args.storage), tt.args.mType, tt.args.mName)
 			if got != tt.want {
 				t.Errorf("getValue() got = %v, want %v", got, tt.want)
 			}
@@ -1,25 +1,29 @@
 package main
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/rsa"
 	"io"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
-	"github.com/kishenkoilya/metricsalerts/internal/filerw"
+	"github.com/kishenkoilya/metricsalerts/internal/envelope"
 	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
-	"github.com/kishenkoilya/metricsalerts/internal/psqlinteraction"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
 )
 
 type HandlerVars struct {
-	storage         *memstorage.MemStorage
-	syncFileWriter  *filerw.Producer
-	psqlConnectLine *string
-	db              *psqlinteraction.DBConnection
-	key             *string
+	storage storage.Storage
+	key     *string
+	alerts  *AlertEngine
+	otlp    *CumulativeTracker
+	influx  *CumulativeTracker
+	broker  *memstorage.Broker
 }
 
 func ParamsMiddleware(next httprouter.Handle, handlerVars *HandlerVars) httprouter.Handle {
@@ -68,6 +72,13 @@ func (lrw *LogResponseWriter) Write(b []byte) (int, error) {
 	return size, err
 }
 
+// Flush нужен потоковым ответам, например /stream
+func (lrw *LogResponseWriter) Flush() {
+	if f, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // Переопределение WriteHeader метода для записи статуса ответа
 func (lrw *LogResponseWriter) WriteHeader(statusCode int) {
 	if !lrw.IsWritten {
@@ -104,3 +115,121 @@ type gzipWriter struct {
 func (w gzipWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
+
+// DecryptMiddleware расшифровывает тело запроса, помеченного заголовком envelope.Header,
+// закрытым ключом key до того, как его прочитает обработчик. Если ключ задан,
+// незашифрованные запросы отклоняются, если не задан - зашифрованные.
+func DecryptMiddleware(next httprouter.Handle, key *rsa.PrivateKey) httprouter.Handle {
+	return httprouter.Handle(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		scheme := r.Header.Get(envelope.Header)
+		if scheme == "" && key == nil {
+			next(w, r, ps)
+			return
+		}
+		if scheme == "" {
+			http.Error(w, "request must be encrypted", http.StatusBadRequest)
+			return
+		}
+		if key == nil || scheme != envelope.Scheme {
+			http.Error(w, "unsupported encryption", http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		plain, err := envelope.Open(key, body)
+		if err != nil {
+			sugar.Errorw(err.Error(), "event", "decrypt request")
+			http.Error(w, "failed to decrypt request", http.StatusBadRequest)
+			return
+		}
+		r.Header.Del(envelope.Header)
+		r.Body = io.NopCloser(bytes.NewReader(plain))
+		r.ContentLength = int64(len(plain))
+		next(w, r, ps)
+	})
+}
+
+// parseSubnets разбирает список CIDR через запятую.
+func parseSubnets(s string) ([]*net.IPNet, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var subnets []*net.IPNet
+	for _, cidr := range strings.Split(s, ",") {
+		_, subnet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
+// ipTrusted проверяет, что адрес ip входит в одну из подсетей subnets.
+// Пустой список подсетей разрешает любой адрес.
+func ipTrusted(ip string, subnets []*net.IPNet) bool {
+	if len(subnets) == 0 {
+		return true
+	}
+	addr := net.ParseIP(strings.TrimSpace(ip))
+	if addr == nil {
+		return false
+	}
+	for _, subnet := range subnets {
+		if subnet.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrIP возвращает IP из сетевого адреса addr, например источника пакета StatsD.
+func addrIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// requestIP возвращает адрес клиента из заголовка X-Real-IP, который ставит
+// агент, а если заголовка нет (например, у Telegraf) - адрес соединения.
+func requestIP(r *http.Request) string {
+	if ip := r.Header.Get("X-Real-IP"); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// TrustedSubnetMiddleware пропускает только запросы, у которых адрес клиента
+// (см. requestIP) входит в доверенные подсети, остальным отвечает 403.
+func TrustedSubnetMiddleware(next httprouter.Handle, subnets []*net.IPNet) httprouter.Handle {
+	return httprouter.Handle(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if !ipTrusted(requestIP(r), subnets) {
+			http.Error(w, "address is not in trusted subnet", http.StatusForbidden)
+			return
+		}
+		next(w, r, ps)
+	})
+}
+
+// ClientCertMiddleware при required пропускает только запросы с сертификатом
+// клиента, проверенным по -tls-client-ca, остальным отвечает 403. Им закрыты
+// только маршруты записи, чтобы страницы для браузера открывались без сертификата.
+func ClientCertMiddleware(next httprouter.Handle, required bool) httprouter.Handle {
+	return httprouter.Handle(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if required && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			http.Error(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		next(w, r, ps)
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/envelope"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+func TestDecryptMiddleware(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(`[{"id":"PollCount","type":"counter","delta":5}]`))
+	gz.Close()
+	sealed, err := envelope.Seal(&priv.PublicKey, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := envelope.Seal(&other.PublicKey, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		key       *rsa.PrivateKey
+		body      []byte
+		encrypted bool
+		status    int
+		want      int64
+	}{
+		{name: "Test1", key: priv, body: sealed, encrypted: true, status: http.StatusOK, want: 5},
+		// с ключом незашифрованные запросы отклоняются
+		{name: "Test2", key: priv, body: buf.Bytes(), status: http.StatusBadRequest},
+		{name: "Test3", key: priv, body: foreign, encrypted: true, status: http.StatusBadRequest},
+		{name: "Test4", key: nil, body: sealed, encrypted: true, status: http.StatusBadRequest},
+		{name: "Test5", key: nil, body: buf.Bytes(), status: http.StatusOK, want: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := memstorage.NewMemStorage()
+			handlerVars := &HandlerVars{storage: storage.NewMemoryStorage(mem), key: new(string)}
+			router := httprouter.New()
+			router.POST("/updates/", DecryptMiddleware(ParamsMiddleware(massUpdatePage, handlerVars), tt.key))
+
+			r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Encoding", "gzip")
+			if tt.encrypted {
+				r.Header.Set(envelope.Header, envelope.Scheme)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.status, w.Body.String())
+			}
+			got, _ := mem.GetCounter("PollCount")
+			if got != tt.want {
+				t.Errorf("PollCount = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrustedSubnetMiddleware(t *testing.T) {
+	subnets, err := parseSubnets("192.168.1.0/24, 10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = parseSubnets("192.168.1.0"); err == nil {
+		t.Error("parseSubnets() should fail without prefix length")
+	}
+	tests := []struct {
+		name       string
+		subnets    []*net.IPNet
+		realIP     string
+		remoteAddr string
+		status     int
+	}{
+		{name: "Test1", subnets: subnets, realIP: "192.168.1.15", status: http.StatusOK},
+		{name: "Test2", subnets: subnets, realIP: "10.20.30.40", status: http.StatusOK},
+		{name: "Test3", subnets: subnets, realIP: "192.168.2.15", status: http.StatusForbidden},
+		{name: "Test4", subnets: subnets, realIP: "", status: http.StatusForbidden},
+		{name: "Test5", subnets: subnets, realIP: "localhost", status: http.StatusForbidden},
+		{name: "Test6", subnets: nil, realIP: "", status: http.StatusOK},
+		// без X-Real-IP проверяется адрес соединения
+		{name: "Test7", subnets: subnets, remoteAddr: "10.1.2.3:5555", status: http.StatusOK},
+		{name: "Test8", subnets: subnets, remoteAddr: "172.16.0.1:5555", status: http.StatusForbidden},
+		{name: "Test9", subnets: subnets, realIP: "172.16.0.1", remoteAddr: "10.1.2.3:5555", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := httprouter.New()
+			router.POST("/update/", TrustedSubnetMiddleware(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+				w.WriteHeader(http.StatusOK)
+			}, tt.subnets))
+			r := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %v, want %v", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestClientCertMiddleware(t *testing.T) {
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	tests := []struct {
+		name     string
+		required bool
+		state    *tls.ConnectionState
+		status   int
+	}{
+		{name: "Test1", required: true, state: verified, status: http.StatusOK},
+		{name: "Test2", required: true, state: &tls.ConnectionState{}, status: http.StatusForbidden},
+		{name: "Test3", required: true, state: nil, status: http.StatusForbidden},
+		{name: "Test4", required: false, state: nil, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := httprouter.New()
+			router.POST("/update/", ClientCertMiddleware(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+				w.WriteHeader(http.StatusOK)
+			}, tt.required))
+			r := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			r.TLS = tt.state
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %v, want %v", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+type WebhookPayload struct {
+	Firing   int       `json:"firing"`
+	Resolved int       `json:"resolved"`
+	Alerts   []Alert   `json:"alerts"`
+	SentAt   time.Time `json:"sentAt"`
+}
+
+// WebhookNotifier копит переходы алертов и раз в groupInterval отправляет их
+// одной пачкой. Переходы правила схлопываются (см. addTransition), поэтому
+// "мигающая" метрика не может завалить получателей уведомлениями.
+type WebhookNotifier struct {
+	urls          []string
+	key           string
+	client        *http.Client
+	groupInterval time.Duration
+	maxAttempts   int
+	backoff       time.Duration
+
+	mutex sync.Mutex
+	// переходы по правилам с прошлой отправки
+	pending map[string][]Alert
+	// переходы, которые не удалось доставить на адрес; уходят туда со следующей пачкой
+	undelivered map[string]map[string][]Alert
+}
+
+func NewWebhookNotifier(urls []string, key string, groupInterval time.Duration) *WebhookNotifier {
+	return &WebhookNotifier{
+		urls:          urls,
+		key:           key,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		groupInterval: groupInterval,
+		maxAttempts:   4,
+		backoff:       time.Second,
+		pending:       make(map[string][]Alert),
+		undelivered:   make(map[string]map[string][]Alert),
+	}
+}
+
+// addTransition добавляет переход в очередь правила. В очереди не больше двух
+// переходов: возврат в состояние первого из них схлопывает очередь в последний
+// переход, а пара firing и resolved сохраняется, чтобы получатели не увидели
+// resolved для алерта, о срабатывании которого не знали.
+func addTransition(pending map[string][]Alert, alert Alert) {
+	queue := pending[alert.Rule]
+	switch {
+	case len(queue) == 0 || queue[0].State == alert.State:
+		queue = []Alert{alert}
+	case len(queue) == 1:
+		queue = append(queue, alert)
+	default:
+		queue[1] = alert
+	}
+	pending[alert.Rule] = queue
+}
+
+func mergeTransitions(dst, src map[string][]Alert) {
+	for _, queue := range src {
+		for _, alert := range queue {
+			addTransition(dst, alert)
+		}
+	}
+}
+
+func newWebhookPayload(pending map[string][]Alert) WebhookPayload {
+	rules := make([]string, 0, len(pending))
+	for rule := range pending {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	payload := WebhookPayload{SentAt: time.Now()}
+	for _, rule := range rules {
+		for _, alert := range pending[rule] {
+			payload.Alerts = append(payload.Alerts, alert)
+			if alert.State == AlertFiring {
+				payload.Firing++
+			} else {
+				payload.Resolved++
+			}
+		}
+	}
+	return payload
+}
+
+func (n *WebhookNotifier) Notify(alerts []Alert) {
+	n.mutex.Lock()
+	for _, alert := range alerts {
+		addTransition(n.pending, alert)
+	}
+	n.mutex.Unlock()
+}
+
+// Flush отправляет накопленные уведомления на все адреса. То, что не удалось
+// доставить на адрес, остаётся в очереди этого адреса до следующей отправки.
+func (n *WebhookNotifier) Flush(ctx context.Context) error {
+	n.mutex.Lock()
+	batches := make(map[string]map[string][]Alert, len(n.urls))
+	for _, url := range n.urls {
+		batch := n.undelivered[url]
+		delete(n.undelivered, url)
+		if batch == nil {
+			batch = make(map[string][]Alert)
+		}
+		mergeTransitions(batch, n.pending)
+		if len(batch) > 0 {
+			batches[url] = batch
+		}
+	}
+	n.pending = make(map[string][]Alert)
+	n.mutex.Unlock()
+
+	var lastErr error
+	for _, url := range n.urls {
+		batch, ok := batches[url]
+		if !ok {
+			continue
+		}
+		payload := newWebhookPayload(batch)
+		body, err := json.Marshal(&payload)
+		if err == nil {
+			err = n.deliver(ctx, url, body)
+		}
+		if err != nil {
+			sugar.Errorln("webhook delivery failed", url, err.Error())
+			lastErr = err
+			n.requeue(url, batch)
+		}
+	}
+	return lastErr
+}
+
+// requeue возвращает недоставленные переходы в очередь адреса перед теми,
+// что успели туда попасть за время отправки.
+func (n *WebhookNotifier) requeue(url string, batch map[string][]Alert) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if newer, ok := n.undelivered[url]; ok {
+		mergeTransitions(batch, newer)
+	}
+	n.undelivered[url] = batch
+}
+
+func (n *WebhookNotifier) deliver(ctx context.Context, url string, body []byte) error {
+	var err error
+	delay := n.backoff
+	for i := 0; i < n.maxAttempts; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			delay *= 2
+		}
+		err = n.post(ctx, url, body)
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("all %d attempts failed: %w", n.maxAttempts, err)
+}
+
+func (n *WebhookNotifier) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.key != "" {
+		req.Header.Set("HashSHA256", generateHMACSHA256(body, n.key))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (n *WebhookNotifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.groupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n.Flush(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookReceiver struct {
+	mutex    sync.Mutex
+	payloads []WebhookPayload
+	signs    []string
+	failures int
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rcv.mutex.Lock()
+	defer rcv.mutex.Unlock()
+	if rcv.failures > 0 {
+		rcv.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var payload WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rcv.payloads = append(rcv.payloads, payload)
+	rcv.signs = append(rcv.signs, r.Header.Get("HashSHA256")+"|"+generateHMACSHA256(body, "secret"))
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestWebhookNotifier_Flush(t *testing.T) {
+	rcv := &webhookReceiver{failures: 2}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	notifier := NewWebhookNotifier([]string{srv.URL}, "secret", time.Minute)
+	notifier.backoff = time.Millisecond
+
+	// мигающая метрика: несколько переходов одного правила за интервал группировки
+	notifier.Notify([]Alert{{Rule: "HighHeap", State: AlertFiring}})
+	notifier.Notify([]Alert{{Rule: "HighHeap", State: AlertResolved}, {Rule: "PollStalled", State: AlertFiring}})
+	notifier.Notify([]Alert{{Rule: "HighHeap", State: AlertFiring}})
+
+	if err := notifier.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(rcv.payloads) != 1 {
+		t.Fatalf("got %d payloads, want 1", len(rcv.payloads))
+	}
+	payload := rcv.payloads[0]
+	if len(payload.Alerts) != 2 || payload.Firing != 2 || payload.Resolved != 0 {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+	if payload.Alerts[0].Rule != "HighHeap" || payload.Alerts[0].State != AlertFiring {
+		t.Errorf("unexpected first alert %+v", payload.Alerts[0])
+	}
+	for _, s := range rcv.signs {
+		if s[:len(s)/2] != s[len(s)/2+1:] {
+			t.Errorf("HashSHA256 header does not match body: %s", s)
+		}
+	}
+
+	// пустая очередь ничего не отправляет
+	if err := notifier.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(rcv.payloads) != 1 {
+		t.Errorf("got %d payloads after empty flush, want 1", len(rcv.payloads))
+	}
+}
+
+func TestWebhookNotifier_FlushGivesUp(t *testing.T) {
+	rcv := &webhookReceiver{failures: 10}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	notifier := NewWebhookNotifier([]string{srv.URL}, "", time.Minute)
+	notifier.backoff = time.Millisecond
+	notifier.Notify([]Alert{{Rule: "HighHeap", State: AlertFiring}})
+
+	if err := notifier.Flush(context.Background()); err == nil {
+		t.Errorf("Flush() expected error")
+	}
+	if rcv.failures != 10-notifier.maxAttempts {
+		t.Errorf("receiver got %d attempts, want %d", 10-rcv.failures, notifier.maxAttempts)
+	}
+
+	// недоставленный алерт уходит со следующей пачкой
+	rcv.failures = 0
+	notifier.Notify([]Alert{{Rule: "PollStalled", State: AlertFiring}})
+	if err := notifier.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(rcv.payloads) != 1 || len(rcv.payloads[0].Alerts) != 2 || rcv.payloads[0].Alerts[0].Rule != "HighHeap" {
+		t.Errorf("unexpected payloads %+v", rcv.payloads)
+	}
+}
+
+func TestWebhookNotifier_FlushKeepsPair(t *testing.T) {
+	rcv := &webhookReceiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	notifier := NewWebhookNotifier([]string{srv.URL}, "", time.Minute)
+	// алерт сработал и погас за один интервал группировки
+	notifier.Notify([]Alert{{Rule: "HighHeap", State: AlertFiring}})
+	notifier.Notify([]Alert{{Rule: "HighHeap", State: AlertResolved}})
+	if err := notifier.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(rcv.payloads) != 1 {
+		t.Fatalf("got %d payloads, want 1", len(rcv.payloads))
+	}
+	payload := rcv.payloads[0]
+	if len(payload.Alerts) != 2 || payload.Firing != 1 || payload.Resolved != 1 ||
+		payload.Alerts[0].State != AlertFiring || payload.Alerts[1].State != AlertResolved {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+}
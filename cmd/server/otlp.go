@@ -0,0 +1,475 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+// Типы ниже повторяют JSON-кодировку ExportMetricsServiceRequest из OTLP.
+// Поля int64 и uint64 в ней передаются строками.
+
+type otlpInt int64
+
+func (i *otlpInt) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+	*i = otlpInt(v)
+	return nil
+}
+
+const (
+	otlpTemporalityDelta      = 1
+	otlpTemporalityCumulative = 2
+)
+
+// otlpTemporality - AggregationTemporality, числом или именем значения
+type otlpTemporality int
+
+func (t *otlpTemporality) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "AGGREGATION_TEMPORALITY_DELTA":
+		*t = otlpTemporalityDelta
+	case "AGGREGATION_TEMPORALITY_CUMULATIVE":
+		*t = otlpTemporalityCumulative
+	default:
+		v, err := strconv.Atoi(string(data))
+		if err != nil {
+			return errors.New("unknown aggregation temporality " + string(data))
+		}
+		*t = otlpTemporality(v)
+	}
+	return nil
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue"`
+	IntValue    *otlpInt `json:"intValue"`
+	DoubleValue *float64 `json:"doubleValue"`
+	BoolValue   *bool    `json:"boolValue"`
+}
+
+func (v otlpAnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != nil:
+		return strconv.FormatInt(int64(*v.IntValue), 10)
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	}
+	return ""
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes"`
+	StartTimeUnixNano otlpInt        `json:"startTimeUnixNano"`
+	AsInt             *otlpInt       `json:"asInt"`
+	AsDouble          *float64       `json:"asDouble"`
+}
+
+func (p *otlpNumberDataPoint) value() (float64, bool) {
+	switch {
+	case p.AsInt != nil:
+		return float64(*p.AsInt), true
+	case p.AsDouble != nil:
+		return *p.AsDouble, true
+	}
+	return 0, false
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes"`
+	StartTimeUnixNano otlpInt        `json:"startTimeUnixNano"`
+	Count             otlpInt        `json:"count"`
+	Sum               float64        `json:"sum"`
+	BucketCounts      []otlpInt      `json:"bucketCounts"`
+	ExplicitBounds    []float64      `json:"explicitBounds"`
+}
+
+type otlpSummaryDataPoint struct {
+	Attributes     []otlpKeyValue `json:"attributes"`
+	Count          otlpInt        `json:"count"`
+	Sum            float64        `json:"sum"`
+	QuantileValues []struct {
+		Quantile float64 `json:"quantile"`
+		Value    float64 `json:"value"`
+	} `json:"quantileValues"`
+}
+
+type otlpMetric struct {
+	Name  string `json:"name"`
+	Gauge *struct {
+		DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+	} `json:"gauge"`
+	Sum *struct {
+		DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+		AggregationTemporality otlpTemporality       `json:"aggregationTemporality"`
+		IsMonotonic            bool                  `json:"isMonotonic"`
+	} `json:"sum"`
+	Histogram *struct {
+		DataPoints             []otlpHistogramDataPoint `json:"dataPoints"`
+		AggregationTemporality otlpTemporality          `json:"aggregationTemporality"`
+	} `json:"histogram"`
+	Summary *struct {
+		DataPoints []otlpSummaryDataPoint `json:"dataPoints"`
+	} `json:"summary"`
+}
+
+type otlpRequest struct {
+	ResourceMetrics []struct {
+		Resource struct {
+			Attributes []otlpKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeMetrics []struct {
+			Metrics []otlpMetric `json:"metrics"`
+		} `json:"scopeMetrics"`
+	} `json:"resourceMetrics"`
+}
+
+// otlpLabels объединяет атрибуты ресурса и точки, атрибуты точки важнее.
+func otlpLabels(resource, point []otlpKeyValue) map[string]string {
+	if len(resource)+len(point) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(resource)+len(point))
+	for _, kv := range resource {
+		labels[kv.Key] = kv.Value.String()
+	}
+	for _, kv := range point {
+		labels[kv.Key] = kv.Value.String()
+	}
+	return labels
+}
+
+// cumulativeTTL - через сколько без новых точек ряд забывается трекером.
+const cumulativeTTL = time.Hour
+
+type cumulativePoint struct {
+	start int64
+	value float64
+	hist  *memstorage.Histogram
+	// время последней точки ряда
+	seen time.Time
+}
+
+// CumulativeTracker помнит последние накопленные значения рядов OTLP с
+// cumulative-агрегацией, чтобы сохранять в хранилище только приращения.
+// Точка после сброса (новое время начала или значение меньше прежнего)
+// учитывается целиком. Первая точка ряда учитывается целиком, только если ряд
+// начался после запуска сервера, иначе она лишь запоминается как база: её
+// значение могло быть учтено до перезапуска.
+type CumulativeTracker struct {
+	mu     sync.Mutex
+	points map[string]cumulativePoint
+	// время запуска сервера и последней чистки points
+	started time.Time
+	evicted time.Time
+}
+
+func NewCumulativeTracker(started time.Time) *CumulativeTracker {
+	return &CumulativeTracker{points: make(map[string]cumulativePoint), started: started, evicted: started}
+}
+
+// fresh сообщает, что ряд с временем начала start трекер видит впервые за
+// всю его жизнь. Забытый по cumulativeTTL ряд начался раньше now - cumulativeTTL,
+// поэтому такие ряды тоже считаются уже учтёнными.
+func (t *CumulativeTracker) fresh(start int64, now time.Time) bool {
+	horizon := t.started
+	if h := now.Add(-cumulativeTTL); h.After(horizon) {
+		horizon = h
+	}
+	return start > horizon.UnixNano()
+}
+
+// evict забывает ряды без новых точек дольше cumulativeTTL, не чаще раза в минуту.
+func (t *CumulativeTracker) evict(now time.Time) {
+	if now.Sub(t.evicted) < time.Minute {
+		return
+	}
+	t.evicted = now
+	for k, p := range t.points {
+		if now.Sub(p.seen) > cumulativeTTL {
+			delete(t.points, k)
+		}
+	}
+}
+
+// commit запоминает накопленные значения пакета b и забывает давно не
+// обновлявшиеся ряды. Вызывается под mu, а пакет сохраняется уже без
+// блокировки; если сохранить не удалось, значения откатывает rollback.
+func (t *CumulativeTracker) commit(b *otlpBatch) {
+	b.prev = make(map[string]cumulativePoint, len(b.points))
+	for k, v := range b.points {
+		if prev, ok := t.points[k]; ok {
+			b.prev[k] = prev
+		}
+		t.points[k] = v
+	}
+	t.evict(b.now)
+}
+
+// rollback возвращает прежние накопленные значения рядов пакета b, который не
+// удалось сохранить, чтобы его приращения вошли в следующую точку. Ряды, которые
+// уже обновил другой запрос, не трогаются.
+func (t *CumulativeTracker) rollback(b *otlpBatch) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, v := range b.points {
+		if cur, ok := t.points[k]; !ok || cur != v {
+			continue
+		}
+		if prev, ok := b.prev[k]; ok {
+			t.points[k] = prev
+		} else {
+			delete(t.points, k)
+		}
+	}
+}
+
+// otlpBatch - результат разбора запроса: метрики для сохранения, новые
+// накопленные значения и число отклонённых точек.
+type otlpBatch struct {
+	metrics []memstorage.Metrics
+	points  map[string]cumulativePoint
+	// значения рядов до commit, для rollback
+	prev     map[string]cumulativePoint
+	now      time.Time
+	rejected int
+	errors   []string
+}
+
+func (b *otlpBatch) reject(name string, err error) {
+	b.rejected++
+	b.errors = append(b.errors, name+": "+err.Error())
+}
+
+// sumDelta возвращает приращение счётчика для накопленного значения value.
+func (t *CumulativeTracker) sumDelta(b *otlpBatch, key string, start int64, value float64) int64 {
+	prev, ok := b.points[key]
+	if !ok {
+		prev, ok = t.points[key]
+	}
+	b.points[key] = cumulativePoint{start: start, value: value, seen: b.now}
+	switch {
+	case !ok && !t.fresh(start, b.now):
+		return 0
+	case !ok || prev.start != start || value < prev.value:
+		return int64(math.Round(value))
+	}
+	// округляем оба значения, чтобы дробные части не накапливали ошибку
+	return int64(math.Round(value)) - int64(math.Round(prev.value))
+}
+
+// histogramDelta возвращает приращение накопленной гистограммы hist.
+func (t *CumulativeTracker) histogramDelta(b *otlpBatch, key string, start int64, hist *memstorage.Histogram) *memstorage.Histogram {
+	prev, ok := b.points[key]
+	if !ok {
+		prev, ok = t.points[key]
+	}
+	b.points[key] = cumulativePoint{start: start, hist: hist, seen: b.now}
+	if !ok && !t.fresh(start, b.now) {
+		return &memstorage.Histogram{Bounds: append([]float64(nil), hist.Bounds...), Counts: make([]uint64, len(hist.Counts))}
+	}
+	if !ok || prev.start != start || prev.hist == nil || len(prev.hist.Counts) != len(hist.Counts) || hist.Count < prev.hist.Count {
+		return hist.Copy()
+	}
+	delta := hist.Copy()
+	for i := range delta.Counts {
+		if hist.Counts[i] < prev.hist.Counts[i] {
+			return hist.Copy()
+		}
+		delta.Counts[i] -= prev.hist.Counts[i]
+	}
+	delta.Count -= prev.hist.Count
+	delta.Sum -= prev.hist.Sum
+	return delta
+}
+
+// convert переводит запрос OTLP в метрики: Sum с монотонным ростом - в
+// счётчики, остальные Sum и Gauge - в gauge, Histogram - в гистограммы,
+// Summary - в gauge name_count, name_sum и name с меткой quantile.
+func (t *CumulativeTracker) convert(ctx context.Context, store storage.Storage, req *otlpRequest, now time.Time) *otlpBatch {
+	b := &otlpBatch{points: make(map[string]cumulativePoint), now: now}
+	// gauge из этого запроса для delta-агрегации немонотонных Sum
+	gauges := make(map[string]float64)
+	add := func(metric memstorage.Metrics) {
+		if code, _ := validateValues(metric.MType, metric.ID); code != http.StatusOK {
+			b.reject(metric.ID, errors.New("bad metric name"))
+			return
+		}
+		b.metrics = append(b.metrics, metric)
+	}
+	for _, rm := range req.ResourceMetrics {
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				switch {
+				case m.Gauge != nil:
+					for _, p := range m.Gauge.DataPoints {
+						value, ok := p.value()
+						if !ok {
+							b.reject(m.Name, errors.New("data point has no value"))
+							continue
+						}
+						add(memstorage.Metrics{ID: m.Name, MType: "gauge", Value: &value, Labels: otlpLabels(rm.Resource.Attributes, p.Attributes)})
+					}
+				case m.Sum != nil:
+					cumulative := m.Sum.AggregationTemporality == otlpTemporalityCumulative
+					for _, p := range m.Sum.DataPoints {
+						value, ok := p.value()
+						if !ok {
+							b.reject(m.Name, errors.New("data point has no value"))
+							continue
+						}
+						labels := otlpLabels(rm.Resource.Attributes, p.Attributes)
+						key := memstorage.SeriesKey(m.Name, labels)
+						switch {
+						case m.Sum.IsMonotonic && cumulative:
+							delta := t.sumDelta(b, "counter "+key, int64(p.StartTimeUnixNano), value)
+							add(memstorage.Metrics{ID: m.Name, MType: "counter", Delta: &delta, Labels: labels})
+						case m.Sum.IsMonotonic:
+							delta := int64(math.Round(value))
+							add(memstorage.Metrics{ID: m.Name, MType: "counter", Delta: &delta, Labels: labels})
+						case cumulative:
+							gauges[key] = value
+							add(memstorage.Metrics{ID: m.Name, MType: "gauge", Value: &value, Labels: labels})
+						default:
+							current, ok := gauges[key]
+							if !ok {
+								if res, err := store.Get(ctx, "gauge", m.Name, labels); err == nil && res.Value != nil {
+									current = *res.Value
+								}
+							}
+							value += current
+							gauges[key] = value
+							add(memstorage.Metrics{ID: m.Name, MType: "gauge", Value: &value, Labels: labels})
+						}
+					}
+				case m.Histogram != nil:
+					for _, p := range m.Histogram.DataPoints {
+						hist := &memstorage.Histogram{Bounds: p.ExplicitBounds, Sum: p.Sum, Count: uint64(p.Count)}
+						for _, c := range p.BucketCounts {
+							hist.Counts = append(hist.Counts, uint64(c))
+						}
+						if err := hist.Validate(); err != nil {
+							b.reject(m.Name, err)
+							continue
+						}
+						labels := otlpLabels(rm.Resource.Attributes, p.Attributes)
+						if m.Histogram.AggregationTemporality == otlpTemporalityCumulative {
+							hist = t.histogramDelta(b, "histogram "+memstorage.SeriesKey(m.Name, labels), int64(p.StartTimeUnixNano), hist)
+						}
+						add(memstorage.Metrics{ID: m.Name, MType: "histogram", Histogram: hist, Labels: labels})
+					}
+				case m.Summary != nil:
+					for _, p := range m.Summary.DataPoints {
+						labels := otlpLabels(rm.Resource.Attributes, p.Attributes)
+						count, sum := float64(p.Count), p.Sum
+						add(memstorage.Metrics{ID: m.Name + "_count", MType: "gauge", Value: &count, Labels: labels})
+						add(memstorage.Metrics{ID: m.Name + "_sum", MType: "gauge", Value: &sum, Labels: labels})
+						for _, q := range p.QuantileValues {
+							value := q.Value
+							qLabels := map[string]string{"quantile": strconv.FormatFloat(q.Quantile, 'g', -1, 64)}
+							for k, v := range labels {
+								qLabels[k] = v
+							}
+							add(memstorage.Metrics{ID: m.Name, MType: "gauge", Value: &value, Labels: qLabels})
+						}
+					}
+				default:
+					b.reject(m.Name, errors.New("unsupported metric type"))
+				}
+			}
+		}
+	}
+	return b
+}
+
+// otlpMetricsPage принимает метрики OTLP/HTTP в JSON-кодировке, как
+// POST /v1/metrics у OpenTelemetry Collector.
+func otlpMetricsPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	handlerVars := r.Context().Value(HandlerVars{}).(*HandlerVars)
+	sugar.Infoln("otlpMetricsPage")
+
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/json") {
+		http.Error(w, "only JSON encoding is supported", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	reqBody := r.Body
+	if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
+		var err error
+		reqBody, err = gzip.NewReader(reqBody)
+		if err != nil {
+			http.Error(w, "gzip.NewReader failed", http.StatusBadRequest)
+			return
+		}
+	}
+
+	bodyBytes, err := io.ReadAll(reqBody)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+
+	if checkSign(r, bodyBytes, handlerVars) != http.StatusOK {
+		http.Error(w, "Sign hashes are not equal", http.StatusBadRequest)
+		return
+	}
+
+	var req otlpRequest
+	if err = json.Unmarshal(bodyBytes, &req); err != nil {
+		http.Error(w, "json.Unmarshal failed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tracker := handlerVars.otlp
+	tracker.mu.Lock()
+	batch := tracker.convert(r.Context(), handlerVars.storage, &req, time.Now())
+	tracker.commit(batch)
+	tracker.mu.Unlock()
+	if len(batch.metrics) != 0 {
+		_, err = handlerVars.storage.SaveBatch(r.Context(), batch.metrics)
+		if err != nil {
+			tracker.rollback(batch)
+			sugar.Errorln("storage.SaveBatch error: ", err.Error())
+			http.Error(w, "storage.SaveBatch failed", storageStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if batch.rejected == 0 {
+		w.Write([]byte("{}"))
+		return
+	}
+	resp, _ := json.Marshal(map[string]interface{}{
+		"partialSuccess": map[string]interface{}{
+			"rejectedDataPoints": fmt.Sprint(batch.rejected),
+			"errorMessage":       strings.Join(batch.errors, "; "),
+		},
+	})
+	w.Write(resp)
+}
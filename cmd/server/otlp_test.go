@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+const otlpResource = `{"attributes": [{"key": "service.name", "value": {"stringValue": "checkout"}}]}`
+
+func otlpBody(metrics string) string {
+	return `{"resourceMetrics": [{"resource": ` + otlpResource + `, "scopeMetrics": [{"metrics": [` + metrics + `]}]}]}`
+}
+
+func otlpSum(value, start string, temporality string) string {
+	return `{"name": "requests", "sum": {"isMonotonic": true, "aggregationTemporality": ` + temporality +
+		`, "dataPoints": [{"startTimeUnixNano": "` + start + `", "asInt": "` + value + `", "attributes": [{"key": "route", "value": {"stringValue": "/pay"}}]}]}}`
+}
+
+func otlpHistogram(counts, count, sum string) string {
+	return `{"name": "latency", "histogram": {"aggregationTemporality": "AGGREGATION_TEMPORALITY_CUMULATIVE", "dataPoints": [{"startTimeUnixNano": "1",
+		"explicitBounds": [0.1, 1], "bucketCounts": [` + counts + `], "count": "` + count + `", "sum": ` + sum + `}]}}`
+}
+
+func Test_otlpMetricsPage(t *testing.T) {
+	mem := memstorage.NewMemStorage()
+	started := time.Now()
+	handlerVars := &HandlerVars{storage: storage.NewMemoryStorage(mem), key: new(string), otlp: NewCumulativeTracker(started)}
+	router := httprouter.New()
+	router.POST("/v1/metrics", ParamsMiddleware(otlpMetricsPage, handlerVars))
+	labels := map[string]string{"service.name": "checkout", "route": "/pay"}
+	resource := map[string]string{"service.name": "checkout"}
+	// ряд начался до запуска сервера и после него
+	before := strconv.FormatInt(started.Add(-time.Hour).UnixNano(), 10)
+	after := strconv.FormatInt(started.Add(time.Second).UnixNano(), 10)
+
+	tests := []struct {
+		name         string
+		body         string
+		contentType  string
+		status       int
+		wantRequests int64
+		wantRejected bool
+	}{
+		// первая точка ряда, начавшегося до запуска, только запоминается
+		{name: "Test1", body: otlpBody(otlpSum("10", before, "2")), status: http.StatusOK, wantRequests: 0},
+		{name: "Test2", body: otlpBody(otlpSum("15", before, "2")), status: http.StatusOK, wantRequests: 5},
+		// сброс счётчика у клиента: новое время начала
+		{name: "Test3", body: otlpBody(otlpSum("4", after, "2")), status: http.StatusOK, wantRequests: 9},
+		{name: "Test4", body: otlpBody(otlpSum("3", "0", "\"AGGREGATION_TEMPORALITY_DELTA\"")), status: http.StatusOK, wantRequests: 12},
+		{name: "Test5", body: otlpBody(otlpHistogram(`"1", "2", "0"`, "3", "1.5")), status: http.StatusOK, wantRequests: 12},
+		{name: "Test6", body: otlpBody(otlpHistogram(`"2", "3", "1"`, "6", "4")), status: http.StatusOK, wantRequests: 12},
+		{name: "Test7", body: otlpBody(`{"name": "temperature", "gauge": {"dataPoints": [{"asDouble": 21.5}]}},
+			{"name": "rpc", "summary": {"dataPoints": [{"count": "4", "sum": 2, "quantileValues": [{"quantile": 0.5, "value": 0.4}]}]}},
+			{"name": "123", "gauge": {"dataPoints": [{"asDouble": 1}]}}`), status: http.StatusOK, wantRequests: 12, wantRejected: true},
+		{name: "Test8", body: "{", status: http.StatusBadRequest, wantRequests: 12},
+		{name: "Test9", body: otlpBody(otlpSum("1", "0", "1")), contentType: "application/x-protobuf", status: http.StatusUnsupportedMediaType, wantRequests: 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			gz.Write([]byte(tt.body))
+			gz.Close()
+			r := httptest.NewRequest(http.MethodPost, "/v1/metrics", &buf)
+			r.Header.Set("Content-Encoding", "gzip")
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			} else {
+				r.Header.Set("Content-Type", "application/json")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("otlpMetricsPage() status = %v, want %v: %s", w.Code, tt.status, w.Body.String())
+			}
+			if got := strings.Contains(w.Body.String(), "partialSuccess"); got != tt.wantRejected {
+				t.Errorf("otlpMetricsPage() response = %s, wantRejected %v", w.Body.String(), tt.wantRejected)
+			}
+			_, m := mem.GetLabeledMetrics("counter", "requests", labels)
+			if m == nil || *m.Delta != tt.wantRequests {
+				t.Errorf("requests = %v, want %v", m, tt.wantRequests)
+			}
+		})
+	}
+
+	_, m := mem.GetLabeledMetrics("histogram", "latency", resource)
+	if m == nil {
+		t.Fatal("latency histogram not found")
+	}
+	// накопленные гистограммы не должны учитываться дважды
+	got, _ := json.Marshal(m.Histogram)
+	if want := `{"bounds":[0.1,1],"counts":[1,1,1],"sum":2.5,"count":3}`; string(got) != want {
+		t.Errorf("latency = %s, want %s", got, want)
+	}
+	if _, m = mem.GetLabeledMetrics("gauge", "temperature", resource); m == nil || *m.Value != 21.5 {
+		t.Errorf("temperature = %v, want 21.5", m)
+	}
+	if _, m = mem.GetLabeledMetrics("gauge", "rpc", map[string]string{"service.name": "checkout", "quantile": "0.5"}); m == nil || *m.Value != 0.4 {
+		t.Errorf("rpc{quantile=0.5} = %v, want 0.4", m)
+	}
+	if _, m = mem.GetLabeledMetrics("gauge", "rpc_count", resource); m == nil || *m.Value != 4 {
+		t.Errorf("rpc_count = %v, want 4", m)
+	}
+}
+
+func TestCumulativeTracker(t *testing.T) {
+	started := time.Now()
+	tracker := NewCumulativeTracker(started)
+	afterStart := started.Add(time.Second).UnixNano()
+
+	tests := []struct {
+		name  string
+		now   time.Time
+		start int64
+		value float64
+		want  int64
+	}{
+		// ряд начался после запуска сервера: учитывается целиком
+		{name: "Test1", now: started.Add(time.Minute), start: afterStart, value: 10, want: 10},
+		{name: "Test2", now: started.Add(2 * time.Minute), start: afterStart, value: 12, want: 2},
+		// ряд забыт по TTL и вернулся с прежним временем начала: только база
+		{name: "Test3", now: started.Add(2*time.Minute + 2*cumulativeTTL), start: afterStart, value: 20, want: 0},
+		{name: "Test4", now: started.Add(3*time.Minute + 2*cumulativeTTL), start: afterStart, value: 25, want: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// чистку запускают и запросы с другими рядами
+			tracker.evict(tt.now)
+			b := &otlpBatch{points: make(map[string]cumulativePoint), now: tt.now}
+			if got := tracker.sumDelta(b, "counter requests", tt.start, tt.value); got != tt.want {
+				t.Errorf("sumDelta() = %v, want %v", got, tt.want)
+			}
+			for k, v := range b.points {
+				tracker.points[k] = v
+			}
+		})
+	}
+}
+
+func TestCumulativeTracker_rollback(t *testing.T) {
+	tracker := NewCumulativeTracker(time.Now())
+	now := time.Now()
+	send := func(value float64) (*otlpBatch, int64) {
+		tracker.mu.Lock()
+		defer tracker.mu.Unlock()
+		b := &otlpBatch{points: make(map[string]cumulativePoint), now: now}
+		delta := tracker.sumDelta(b, "requests", 0, value)
+		tracker.commit(b)
+		return b, delta
+	}
+	send(10)
+	if _, delta := send(15); delta != 5 {
+		t.Fatalf("sumDelta() = %v, want 5", delta)
+	}
+	// пакет не сохранился: его приращение входит в следующую точку
+	failed, _ := send(20)
+	tracker.rollback(failed)
+	if _, delta := send(22); delta != 7 {
+		t.Errorf("sumDelta() after rollback = %v, want 7", delta)
+	}
+	// ряд уже обновил другой запрос: откат его не трогает
+	failed, _ = send(30)
+	send(31)
+	tracker.rollback(failed)
+	if _, delta := send(33); delta != 2 {
+		t.Errorf("sumDelta() after stale rollback = %v, want 2", delta)
+	}
+}
@@ -10,23 +10,46 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/jackc/pgerrcode"
 	"github.com/julienschmidt/httprouter"
 	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
-	"github.com/kishenkoilya/metricsalerts/internal/psqlinteraction"
 )
 
 func printAllPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	handlerVars := r.Context().Value(HandlerVars{}).(*HandlerVars)
 	sugar.Infoln("printAllPage")
-	path := strings.Trim(r.URL.Path, "/")
-	if path != "" {
-		w.WriteHeader(http.StatusNotFound)
+	metrics, err := handlerVars.storage.List(r.Context())
+	if err != nil {
+		http.Error(w, "storage.List failed", http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(handlerVars.storage.PrintAll()))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(printAll(metrics)))
+}
+
+func printAll(metrics []memstorage.Metrics) string {
+	var counters, gauges, histograms string
+	for _, metric := range metrics {
+		id := memstorage.SeriesKey(metric.ID, metric.Labels)
+		if metric.Histogram != nil {
+			histograms += id + ": count " + fmt.Sprint(metric.Histogram.Count) + ", sum " + fmt.Sprint(metric.Histogram.Sum) + "\n"
+		} else if metric.Delta != nil {
+			counters += id + ": " + fmt.Sprint(*metric.Delta) + "\n"
+		} else if metric.Value != nil {
+			gauges += id + ": " + fmt.Sprint(*metric.Value) + "\n"
+		}
+	}
+	res := ""
+	if counters != "" {
+		res += "Counters:\n" + counters
+	}
+	if gauges != "" {
+		res += "Gauges:\n" + gauges
+	}
+	if histograms != "" {
+		res += "Histograms:\n" + histograms
+	}
+	return res
 }
 
 func getPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -43,7 +66,7 @@ func getPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		http.Error(w, "Error validating type and name", statusRes)
 		return
 	}
-	statusRes, body = getValue(handlerVars.storage, mType, mName)
+	statusRes, body = getValue(r.Context(), handlerVars.storage, mType, mName)
 	if statusRes != http.StatusOK {
 		// sugar.Errorln("getValue error: ", err.Error())
 		http.Error(w, "Error getting value", statusRes)
@@ -57,8 +80,7 @@ func getPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 func pingPostgrePage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	handlerVars := r.Context().Value(HandlerVars{}).(*HandlerVars)
 	sugar.Infoln("pingPostgrePage")
-	dbPingFunc := psqlinteraction.PingPSQL(*handlerVars.psqlConnectLine)
-	_, err := Retrypg(pgerrcode.OperatorIntervention, dbPingFunc)
+	err := handlerVars.storage.Ping(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -85,16 +107,10 @@ func updatePage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		http.Error(w, "Error parsing value", http.StatusBadRequest)
 		return
 	}
-	statusRes, metric = handlerVars.storage.SaveMetric(metric)
-	if statusRes != http.StatusOK {
-		// sugar.Errorln("saveValue error: ", err.Error())
-		http.Error(w, "Error parsing value", statusRes)
-		return
-	}
-	statusRes = writeValue(handlerVars, mType, mName, mVal)
-	if statusRes != http.StatusOK {
-		// sugar.Errorln("saveValue error: ", err.Error())
-		http.Error(w, "Error writing value to storage", statusRes)
+	metric, err = handlerVars.storage.Save(r.Context(), metric)
+	if err != nil {
+		sugar.Errorln("storage.Save error: ", err.Error())
+		http.Error(w, "Error writing value to storage", storageStatus(err))
 		return
 	}
 	body += metric.StringMetric()
@@ -145,12 +161,13 @@ func getJSONPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		return
 	}
 
-	statusRes, resp = handlerVars.storage.GetMetrics(req.MType, req.ID)
-	if statusRes != http.StatusOK {
-		// sugar.Errorln("storage.GetMetrics failed: ", statusRes)
-		w.WriteHeader(statusRes)
+	resp, err = handlerVars.storage.Get(r.Context(), req.MType, req.ID, req.Labels)
+	if err != nil {
+		// sugar.Errorln("storage.Get failed: ", err.Error())
+		w.WriteHeader(storageStatus(err))
 		return
 	}
+	statusRes = http.StatusOK
 	resp.PrintMetric()
 
 	respJSON, err := json.Marshal(resp)
@@ -208,23 +225,13 @@ func updateJSONPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params
 		http.Error(w, "json.Marshal failed", http.StatusBadRequest)
 		return
 	}
-	statusRes, req = handlerVars.storage.SaveMetric(req)
-	if statusRes != http.StatusOK {
-		http.Error(w, "storage.SaveMetrics failed", statusRes)
-		return
-	}
-	var mVal string
-	if req.Delta != nil {
-		mVal = fmt.Sprint(req.Delta)
-	} else {
-		mVal = fmt.Sprint(req.Value)
-	}
-	statusRes = writeValue(handlerVars, mType, mName, mVal)
-	if statusRes != http.StatusOK {
-		// sugar.Errorln("saveValue error: ", err.Error())
-		http.Error(w, "Error writing value to storage", statusRes)
+	req, err = handlerVars.storage.Save(r.Context(), req)
+	if err != nil {
+		sugar.Errorln("storage.Save error: ", err.Error())
+		http.Error(w, "storage.Save failed", storageStatus(err))
 		return
 	}
+	statusRes = http.StatusOK
 	respJSON, err := json.Marshal(&req)
 	if err != nil {
 		http.Error(w, "gzip.NewReader failed", http.StatusInternalServerError)
@@ -278,21 +285,23 @@ func massUpdatePage(w http.ResponseWriter, r *http.Request, ps httprouter.Params
 	// 	val.PrintMetric()
 	// }
 
-	statusRes, resp := handlerVars.storage.SaveMetrics(req)
-	if statusRes != http.StatusOK {
-		http.Error(w, "storage.SaveMetrics failed", statusRes)
+	if req == nil || len(*req) == 0 {
+		http.Error(w, "empty metrics batch", http.StatusBadRequest)
 		return
 	}
+
+	resp, err := handlerVars.storage.SaveBatch(r.Context(), *req)
+	if err != nil {
+		sugar.Errorln("storage.SaveBatch error: ", err.Error())
+		http.Error(w, "storage.SaveBatch failed", storageStatus(err))
+		return
+	}
+	statusRes = http.StatusOK
 	// fmt.Println("printing response: ")
-	// for _, val := range *resp {
+	// for _, val := range resp {
 	// 	val.PrintMetric()
 	// }
-	statusRes = writeValues(handlerVars, resp)
-	if statusRes != http.StatusOK {
-		http.Error(w, "writeValues failed", statusRes)
-		return
-	}
-	resp1 := (*resp)[0]
+	resp1 := resp[0]
 	respJSON, err := json.Marshal(&resp1)
 	if err != nil {
 		http.Error(w, "json.Marshal failed", http.StatusInternalServerError)
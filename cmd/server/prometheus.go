@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+)
+
+// sanitizePromName приводит имя метрики к виду [a-zA-Z_:][a-zA-Z0-9_:]*
+func sanitizePromName(name string) string {
+	var b strings.Builder
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+			b.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// formatPromLabels возвращает метки в виде {key="value",...} с экранированием значений.
+func formatPromLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	escaper := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		// в именах меток, в отличие от имён метрик, двоеточие запрещено
+		b.WriteString(strings.ReplaceAll(sanitizePromName(k), ":", "_"))
+		b.WriteString(`="`)
+		b.WriteString(escaper.Replace(labels[k]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatPromValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// renderPrometheus выводит метрики в текстовом формате Prometheus.
+// Разные имена могут совпасть после sanitizePromName, поэтому серии
+// группируются по итоговому имени: у каждого имени один # TYPE.
+func renderPrometheus(metrics []memstorage.Metrics) string {
+	var names []string
+	types := make(map[string]string)
+	groups := make(map[string]*strings.Builder)
+	series := make(map[string]bool)
+	for _, metric := range metrics {
+		name := sanitizePromName(metric.ID)
+		if metric.Histogram == nil && metric.Delta == nil && metric.Value == nil {
+			continue
+		}
+		if mType, ok := types[name]; !ok {
+			names = append(names, name)
+			types[name] = metric.MType
+			groups[name] = &strings.Builder{}
+		} else if mType != metric.MType {
+			// одно имя с разными типами Prometheus не примет, оставляем первый
+			sugar.Warnf("prometheus: skipping %s %q, name %s is already used by %s", metric.MType, metric.ID, name, mType)
+			continue
+		}
+		labels := formatPromLabels(metric.Labels)
+		if series[name+labels] {
+			sugar.Warnf("prometheus: skipping %s %q, series %s%s is already written", metric.MType, metric.ID, name, labels)
+			continue
+		}
+		series[name+labels] = true
+		b := groups[name]
+		switch {
+		case metric.Histogram != nil:
+			writePromHistogram(b, name, metric.Labels, metric.Histogram)
+		case metric.Delta != nil:
+			b.WriteString(name + labels + " " + strconv.FormatInt(*metric.Delta, 10) + "\n")
+		default:
+			b.WriteString(name + labels + " " + formatPromValue(*metric.Value) + "\n")
+		}
+	}
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString("# TYPE " + name + " " + types[name] + "\n")
+		b.WriteString(groups[name].String())
+	}
+	return b.String()
+}
+
+// writePromHistogram выводит гистограмму как набор накопительных корзин _bucket, _sum и _count.
+func writePromHistogram(b *strings.Builder, name string, labels map[string]string, hist *memstorage.Histogram) {
+	var cumulative uint64
+	bucketLabels := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		bucketLabels[k] = v
+	}
+	for i, c := range hist.Counts {
+		cumulative += c
+		le := "+Inf"
+		if i < len(hist.Bounds) {
+			le = formatPromValue(hist.Bounds[i])
+		}
+		bucketLabels["le"] = le
+		b.WriteString(name + "_bucket" + formatPromLabels(bucketLabels) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+	}
+	b.WriteString(name + "_sum" + formatPromLabels(labels) + " " + formatPromValue(hist.Sum) + "\n")
+	b.WriteString(name + "_count" + formatPromLabels(labels) + " " + strconv.FormatUint(hist.Count, 10) + "\n")
+}
+
+func prometheusPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	handlerVars := r.Context().Value(HandlerVars{}).(*HandlerVars)
+	sugar.Infoln("prometheusPage")
+	metrics, err := handlerVars.storage.List(r.Context())
+	if err != nil {
+		http.Error(w, "storage.List failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(renderPrometheus(metrics)))
+}
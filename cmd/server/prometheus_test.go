@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+)
+
+func Test_sanitizePromName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "Test1", in: "HeapAlloc", want: "HeapAlloc"},
+		{name: "Test2", in: "cpu.utilization-1", want: "cpu_utilization_1"},
+		{name: "Test3", in: "1st", want: "_1st"},
+		{name: "Test4", in: "", want: "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizePromName(tt.in); got != tt.want {
+				t.Errorf("sanitizePromName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_renderPrometheus(t *testing.T) {
+	delta := int64(5)
+	value := 0.25
+	other := 1.0
+	metrics := []memstorage.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "PollCount", MType: "gauge", Value: &other},
+		{ID: "Random.Value", MType: "gauge", Value: &value},
+		{ID: "Random.Value", MType: "gauge", Value: &other, Labels: map[string]string{"host": "a\"b", "dc": "eu"}},
+	}
+	want := "# TYPE PollCount counter\n" +
+		"PollCount 5\n" +
+		"# TYPE Random_Value gauge\n" +
+		"Random_Value 0.25\n" +
+		"Random_Value{dc=\"eu\",host=\"a\\\"b\"} 1\n"
+	if got := renderPrometheus(metrics); got != want {
+		t.Errorf("renderPrometheus() = %q, want %q", got, want)
+	}
+}
+
+// Test_renderPrometheusSanitizedNames проверяет, что имена, совпавшие после
+// sanitizePromName, выводятся одной группой с одним # TYPE.
+func Test_renderPrometheusSanitizedNames(t *testing.T) {
+	one, two, three := 1.0, 2.0, 3.0
+	delta := int64(4)
+	metrics := []memstorage.Metrics{
+		{ID: "Heap-Alloc", MType: "gauge", Value: &one, Labels: map[string]string{"host": "a"}},
+		{ID: "Heap.Alloc", MType: "gauge", Value: &two},
+		{ID: "HeapObjects", MType: "gauge", Value: &three},
+		{ID: "Heap_Alloc", MType: "gauge", Value: &three, Labels: map[string]string{"host": "b"}},
+		{ID: "Heap_Alloc", MType: "gauge", Value: &three},
+		{ID: "Heap:Alloc", MType: "counter", Delta: &delta},
+		{ID: "Heap.Alloc", MType: "counter", Delta: &delta},
+	}
+	want := "# TYPE Heap_Alloc gauge\n" +
+		"Heap_Alloc{host=\"a\"} 1\n" +
+		"Heap_Alloc 2\n" +
+		"Heap_Alloc{host=\"b\"} 3\n" +
+		"# TYPE HeapObjects gauge\n" +
+		"HeapObjects 3\n" +
+		"# TYPE Heap:Alloc counter\n" +
+		"Heap:Alloc 4\n"
+	if got := renderPrometheus(metrics); got != want {
+		t.Errorf("renderPrometheus() = %q, want %q", got, want)
+	}
+}
+
+func Test_renderPrometheusHistogram(t *testing.T) {
+	hist := memstorage.NewHistogram([]float64{0.1, 1})
+	hist.Observe(0.05)
+	hist.Observe(0.5)
+	hist.Observe(3)
+	metrics := []memstorage.Metrics{
+		{ID: "SendLatency", MType: "histogram", Histogram: hist, Labels: map[string]string{"host": "a"}},
+	}
+	want := "# TYPE SendLatency histogram\n" +
+		"SendLatency_bucket{host=\"a\",le=\"0.1\"} 1\n" +
+		"SendLatency_bucket{host=\"a\",le=\"1\"} 2\n" +
+		"SendLatency_bucket{host=\"a\",le=\"+Inf\"} 3\n" +
+		"SendLatency_sum{host=\"a\"} 3.55\n" +
+		"SendLatency_count{host=\"a\"} 3\n"
+	if got := renderPrometheus(metrics); got != want {
+		t.Errorf("renderPrometheus() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/query"
+)
+
+// QueryRequest - тело POST /query в формате JSON. Time понимает те же форматы,
+// что и параметры /history, по умолчанию - текущий момент.
+type QueryRequest struct {
+	Query string `json:"query"`
+	Time  string `json:"time,omitempty"`
+}
+
+// queryPage вычисляет выражение из тела запроса: JSON QueryRequest или,
+// при другом Content-Type, само выражение текстом.
+func queryPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	handlerVars := r.Context().Value(HandlerVars{}).(*HandlerVars)
+	sugar.Infoln("queryPage")
+
+	reqBody := r.Body
+	if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
+		var err error
+		reqBody, err = gzip.NewReader(reqBody)
+		if err != nil {
+			http.Error(w, "gzip.NewReader failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	bodyBytes, err := io.ReadAll(reqBody)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusInternalServerError)
+		return
+	}
+	if checkSign(r, bodyBytes, handlerVars) != http.StatusOK {
+		http.Error(w, "Sign hashes are not equal", http.StatusBadRequest)
+		return
+	}
+
+	var req QueryRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(bodyBytes, &req); err != nil {
+			http.Error(w, "json.Unmarshal failed", http.StatusBadRequest)
+			return
+		}
+	} else {
+		req.Query = string(bodyBytes)
+	}
+
+	now := time.Now()
+	at, err := parseTimeParam(req.Time, now, now)
+	if err != nil {
+		http.Error(w, "Error parsing time", http.StatusBadRequest)
+		return
+	}
+	expr, err := query.Parse(req.Query)
+	if err != nil {
+		http.Error(w, "Error parsing query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	series, err := query.Eval(r.Context(), handlerVars.storage, expr, at)
+	if err != nil {
+		http.Error(w, "Error evaluating query", storageStatus(err))
+		return
+	}
+
+	respJSON, err := json.Marshal(series)
+	if err != nil {
+		http.Error(w, "json.Marshal failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(respJSON)
+}
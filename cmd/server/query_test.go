@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/query"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+func Test_queryPage(t *testing.T) {
+	mem := memstorage.NewMemStorage()
+	mem.History = memstorage.NewHistory(time.Hour, 10, 1<<10)
+	mem.PutGauge("CPUutilization1", 10)
+	mem.PutGauge("CPUutilization2", 30)
+	mem.PutCounter("PollCount", 5)
+	mem.PutCounter("PollCount", 7)
+	handlerVars := &HandlerVars{storage: storage.NewMemoryStorage(mem), key: new(string)}
+
+	router := httprouter.New()
+	router.POST("/query", ParamsMiddleware(queryPage, handlerVars))
+
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		status      int
+		want        []query.Series
+	}{
+		{name: "Test1", body: "sum(CPUutilization*)", status: http.StatusOK, want: []query.Series{{Value: 40}}},
+		{name: "Test2", body: `{"query": "increase(PollCount[1m])"}`, contentType: "application/json", status: http.StatusOK,
+			want: []query.Series{{Name: "PollCount", Type: "counter", Value: 7}}},
+		{name: "Test3", body: `{"query": "CPUutilization1", "time": "-1m"}`, contentType: "application/json", status: http.StatusOK,
+			want: []query.Series{{Name: "CPUutilization1", Type: "gauge", Value: 10}}},
+		{name: "Test4", body: "sum(CPUutilization*", status: http.StatusBadRequest},
+		{name: "Test5", body: `{"query": `, contentType: "application/json", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("queryPage() status = %v, want %v: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var got []query.Series
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("queryPage() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].Name != tt.want[i].Name || got[i].Type != tt.want[i].Type || got[i].Value != tt.want[i].Value {
+					t.Errorf("queryPage() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	// без истории функции по истории недоступны
+	mem.History = nil
+	r := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader("rate(PollCount[1m])"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("queryPage() without history status = %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
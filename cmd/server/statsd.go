@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+// Интервал, за который считаются уникальные значения сетов StatsD и
+// сохраняются собственные метрики приёмника
+const statsdInterval = 10 * time.Second
+
+// Сколько пакетов может ждать разбора, остальные отбрасываются
+const statsdQueueSize = 1024
+
+// statsdSample - одна строка StatsD: name:value|type[|@rate][|#tag:value,...]
+type statsdSample struct {
+	name     string
+	value    string
+	mType    string
+	rate     float64
+	relative bool
+	labels   map[string]string
+}
+
+func parseStatsDLine(line string) (*statsdSample, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return nil, errors.New("no metric name in " + line)
+	}
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 || parts[0] == "" {
+		return nil, errors.New("no metric type in " + line)
+	}
+	s := &statsdSample{name: name, value: parts[0], mType: parts[1], rate: 1}
+	switch s.mType {
+	case "c", "g", "ms", "s":
+	default:
+		return nil, errors.New("unknown metric type " + s.mType)
+	}
+	if s.mType != "s" {
+		if _, err := strconv.ParseFloat(s.value, 64); err != nil {
+			return nil, err
+		}
+	}
+	s.relative = s.mType == "g" && (s.value[0] == '+' || s.value[0] == '-')
+	for _, p := range parts[2:] {
+		switch {
+		case strings.HasPrefix(p, "@"):
+			rate, err := strconv.ParseFloat(p[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, errors.New("bad sample rate " + p)
+			}
+			s.rate = rate
+		case strings.HasPrefix(p, "#"):
+			s.labels = make(map[string]string)
+			for _, tag := range strings.Split(p[1:], ",") {
+				k, v, _ := strings.Cut(tag, ":")
+				if k != "" {
+					s.labels[k] = v
+				}
+			}
+		default:
+			return nil, errors.New("unknown field " + p)
+		}
+	}
+	if code, _ := validateValues("gauge", s.name); code != http.StatusOK {
+		return nil, errors.New("bad metric name " + s.name)
+	}
+	return s, nil
+}
+
+// StatsDListener принимает метрики StatsD по UDP: счётчики (c) и gauge (g)
+// сохраняются как есть, таймеры (ms) - в гистограммы в секундах, сеты (s) -
+// как gauge с числом уникальных значений за statsdInterval. Пакеты с адресов
+// вне доверенных подсетей отбрасываются.
+type StatsDListener struct {
+	storage storage.Storage
+	conn    net.PacketConn
+	trusted []*net.IPNet
+	packets chan []byte
+	// уникальные значения сетов за текущий интервал
+	sets map[string]map[string]struct{}
+	// собственные метрики с момента последнего сохранения
+	received   int64
+	dropped    int64
+	untrusted  int64
+	badLines   int64
+	saveErrors int64
+}
+
+func NewStatsDListener(store storage.Storage, address string, trusted []*net.IPNet) (*StatsDListener, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsDListener{
+		storage: store,
+		conn:    conn,
+		trusted: trusted,
+		packets: make(chan []byte, statsdQueueSize),
+		sets:    make(map[string]map[string]struct{}),
+	}, nil
+}
+
+// Run читает пакеты, пока не завершится ctx. Если разбор не успевает, пакеты
+// отбрасываются и учитываются в StatsDPacketsDropped.
+func (l *StatsDListener) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		l.conn.Close()
+	}()
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := l.conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					close(l.packets)
+					return
+				}
+				sugar.Errorw(err.Error(), "event", "read statsd packet")
+				continue
+			}
+			atomic.AddInt64(&l.received, 1)
+			if !ipTrusted(addrIP(addr), l.trusted) {
+				atomic.AddInt64(&l.untrusted, 1)
+				continue
+			}
+			select {
+			case l.packets <- append([]byte(nil), buf[:n]...):
+			default:
+				atomic.AddInt64(&l.dropped, 1)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(statsdInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case packet, ok := <-l.packets:
+			if !ok {
+				return
+			}
+			l.handlePacket(ctx, packet)
+		case <-ticker.C:
+			l.sets = make(map[string]map[string]struct{})
+			l.saveSelfMetrics(ctx)
+		}
+	}
+}
+
+func (l *StatsDListener) handlePacket(ctx context.Context, packet []byte) {
+	var batch []memstorage.Metrics
+	// gauge из этого пакета, чтобы несколько относительных изменений подряд складывались
+	gauges := make(map[string]float64)
+	for _, line := range strings.Split(string(packet), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		s, err := parseStatsDLine(line)
+		if err != nil {
+			atomic.AddInt64(&l.badLines, 1)
+			sugar.Debugw(err.Error(), "event", "parse statsd line")
+			continue
+		}
+		batch = append(batch, l.toMetric(ctx, s, gauges))
+	}
+	if len(batch) == 0 {
+		return
+	}
+	if _, err := l.storage.SaveBatch(ctx, batch); err != nil {
+		atomic.AddInt64(&l.saveErrors, 1)
+		sugar.Errorw(err.Error(), "event", "save statsd metrics")
+	}
+}
+
+func (l *StatsDListener) toMetric(ctx context.Context, s *statsdSample, gauges map[string]float64) memstorage.Metrics {
+	metric := memstorage.Metrics{ID: s.name, Labels: s.labels}
+	switch s.mType {
+	case "c":
+		value, _ := strconv.ParseFloat(s.value, 64)
+		delta := int64(math.Round(value / s.rate))
+		metric.MType = "counter"
+		metric.Delta = &delta
+	case "g":
+		value, _ := strconv.ParseFloat(s.value, 64)
+		key := memstorage.SeriesKey(s.name, s.labels)
+		if s.relative {
+			current, ok := gauges[key]
+			if !ok {
+				if res, err := l.storage.Get(ctx, "gauge", s.name, s.labels); err == nil && res.Value != nil {
+					current = *res.Value
+				}
+			}
+			value += current
+		}
+		gauges[key] = value
+		metric.MType = "gauge"
+		metric.Value = &value
+	case "ms":
+		value, _ := strconv.ParseFloat(s.value, 64)
+		metric.MType = "histogram"
+		metric.Histogram = memstorage.NewHistogram(memstorage.DefaultBuckets)
+		metric.Histogram.Observe(value / 1000)
+	case "s":
+		key := memstorage.SeriesKey(s.name, s.labels)
+		if l.sets[key] == nil {
+			l.sets[key] = make(map[string]struct{})
+		}
+		l.sets[key][s.value] = struct{}{}
+		value := float64(len(l.sets[key]))
+		metric.MType = "gauge"
+		metric.Value = &value
+	}
+	return metric
+}
+
+// saveSelfMetrics сохраняет счётчики приёмника, накопленные с прошлого вызова.
+func (l *StatsDListener) saveSelfMetrics(ctx context.Context) {
+	counters := []struct {
+		name  string
+		value *int64
+	}{
+		{"StatsDPacketsReceived", &l.received},
+		{"StatsDPacketsDropped", &l.dropped},
+		{"StatsDPacketsUntrusted", &l.untrusted},
+		{"StatsDBadLines", &l.badLines},
+		{"StatsDSaveErrors", &l.saveErrors},
+	}
+	var batch []memstorage.Metrics
+	for _, c := range counters {
+		delta := atomic.SwapInt64(c.value, 0)
+		if delta != 0 {
+			batch = append(batch, memstorage.Metrics{ID: c.name, MType: "counter", Delta: &delta})
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+	if _, err := l.storage.SaveBatch(ctx, batch); err != nil {
+		sugar.Errorw(err.Error(), "event", "save statsd self metrics")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+func Test_parseStatsDLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    *statsdSample
+		wantErr bool
+	}{
+		{name: "Test1", line: "requests:1|c", want: &statsdSample{name: "requests", value: "1", mType: "c", rate: 1}},
+		{name: "Test2", line: "requests:3|c|@0.5", want: &statsdSample{name: "requests", value: "3", mType: "c", rate: 0.5}},
+		{name: "Test3", line: "queue:-2|g", want: &statsdSample{name: "queue", value: "-2", mType: "g", rate: 1, relative: true}},
+		{name: "Test4", line: "db.query:320|ms|#host:a,dc:eu", want: &statsdSample{name: "db.query", value: "320", mType: "ms", rate: 1,
+			labels: map[string]string{"host": "a", "dc": "eu"}}},
+		{name: "Test5", line: "users:alice|s", want: &statsdSample{name: "users", value: "alice", mType: "s", rate: 1}},
+		{name: "Test6", line: "requests:1|h", wantErr: true},
+		{name: "Test7", line: "requests:one|c", wantErr: true},
+		{name: "Test8", line: "requests:1|c|@2", wantErr: true},
+		{name: "Test9", line: "requests", wantErr: true},
+		{name: "Test10", line: "123:1|c", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStatsDLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStatsDLine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseStatsDLine() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsDListener_handlePacket(t *testing.T) {
+	mem := memstorage.NewMemStorage()
+	mem.PutGauge("queue", 10)
+	l := &StatsDListener{
+		storage: storage.NewMemoryStorage(mem),
+		sets:    make(map[string]map[string]struct{}),
+	}
+	ctx := context.Background()
+	l.handlePacket(ctx, []byte("requests:3|c|@0.5\nqueue:-2|g\nqueue:+1|g\ndb:250|ms\nusers:alice|s\nusers:bob|s\nusers:alice|s\nbroken"))
+	l.handlePacket(ctx, []byte("requests:1|c\ndb:2000|ms"))
+
+	if got, _ := mem.GetCounter("requests"); got != 7 {
+		t.Errorf("requests = %v, want 7", got)
+	}
+	if got, _ := mem.GetGauge("queue"); got != 9 {
+		t.Errorf("queue = %v, want 9", got)
+	}
+	if got, _ := mem.GetGauge("users"); got != 2 {
+		t.Errorf("users = %v, want 2", got)
+	}
+	hist, ok := mem.GetHistogram("db")
+	if !ok || hist.Count != 2 || hist.Sum != 2.25 {
+		t.Errorf("db = %+v, want 2 observations with sum 2.25", hist)
+	}
+	if l.badLines != 1 {
+		t.Errorf("badLines = %v, want 1", l.badLines)
+	}
+
+	l.saveSelfMetrics(ctx)
+	if got, _ := mem.GetCounter("StatsDBadLines"); got != 1 {
+		t.Errorf("StatsDBadLines = %v, want 1", got)
+	}
+	if l.badLines != 0 {
+		t.Errorf("badLines after save = %v, want 0", l.badLines)
+	}
+}
+
+func TestStatsDListener_trusted(t *testing.T) {
+	tests := []struct {
+		name    string
+		subnets string
+		want    int64
+	}{
+		{name: "Test1", subnets: "127.0.0.0/8", want: 1},
+		{name: "Test2", subnets: "10.0.0.0/8", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subnets, err := parseSubnets(tt.subnets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mem := memstorage.NewMemStorage()
+			l, err := NewStatsDListener(storage.NewMemoryStorage(mem), "127.0.0.1:0", subnets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go l.Run(ctx)
+
+			conn, err := net.Dial("udp", l.conn.LocalAddr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.Write([]byte("requests:1|c"))
+
+			deadline := time.Now().Add(5 * time.Second)
+			for time.Now().Before(deadline) {
+				if got, _ := mem.GetCounter("requests"); got == tt.want && atomic.LoadInt64(&l.untrusted) == 1-tt.want {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if got := atomic.LoadInt64(&l.untrusted); got != 1-tt.want {
+				t.Errorf("untrusted = %v, want %v", got, 1-tt.want)
+			}
+			if got, _ := mem.GetCounter("requests"); got != tt.want {
+				t.Errorf("requests = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+)
+
+// Сколько событий может ждать отправки одному подписчику /stream
+const streamBuffer = 256
+
+// Как часто /stream отправляет комментарий, чтобы соединение не закрылось по простою
+const streamKeepAlive = 15 * time.Second
+
+// streamFilter возвращает фильтр по типам метрик (через запятую) и префиксу имени.
+func streamFilter(types, prefix string) func(*memstorage.Metrics) bool {
+	allowed := make(map[string]bool)
+	for _, t := range strings.Split(types, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			allowed[t] = true
+		}
+	}
+	return func(m *memstorage.Metrics) bool {
+		if len(allowed) != 0 && !allowed[m.MType] {
+			return false
+		}
+		return strings.HasPrefix(m.ID, prefix)
+	}
+}
+
+// streamPage отправляет изменения метрик как Server-Sent Events: событие
+// с именем типа метрики и JSON метрики в data. Параметры type и prefix
+// ограничивают поток. Если клиент не успевает читать, поток закрывается,
+// и клиент должен переподключиться.
+func streamPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	handlerVars := r.Context().Value(HandlerVars{}).(*HandlerVars)
+	sugar.Infoln("streamPage")
+	flusher, ok := w.(http.Flusher)
+	if !ok || handlerVars.broker == nil {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	query := r.URL.Query()
+	sub := handlerVars.broker.Subscribe(streamFilter(query.Get("type"), query.Get("prefix")))
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case metric, ok := <-sub.C:
+			if !ok {
+				sugar.Infoln("stream subscriber dropped")
+				return
+			}
+			data, err := json.Marshal(metric)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", metric.MType, data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
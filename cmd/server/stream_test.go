@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+func Test_streamFilter(t *testing.T) {
+	value := 1.0
+	gauge := &memstorage.Metrics{ID: "HeapAlloc", MType: "gauge", Value: &value}
+	tests := []struct {
+		name   string
+		types  string
+		prefix string
+		want   bool
+	}{
+		{name: "Test1", want: true},
+		{name: "Test2", types: "gauge", prefix: "Heap", want: true},
+		{name: "Test3", types: "counter,histogram", want: false},
+		{name: "Test4", types: "counter, gauge", prefix: "Heap", want: true},
+		{name: "Test5", prefix: "Stack", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := streamFilter(tt.types, tt.prefix)(gauge); got != tt.want {
+				t.Errorf("streamFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_streamPage(t *testing.T) {
+	mem := memstorage.NewMemStorage()
+	mem.Broker = memstorage.NewBroker(streamBuffer)
+	handlerVars := &HandlerVars{storage: storage.NewMemoryStorage(mem), broker: mem.Broker}
+	router := httprouter.New()
+	router.GET("/stream", LoggingMiddleware(ParamsMiddleware(streamPage, handlerVars)))
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/stream?type=gauge&prefix=Heap", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %v", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+	if line, _ := reader.ReadString('\n'); line != ": connected\n" {
+		t.Fatalf("first line = %q", line)
+	}
+	reader.ReadString('\n')
+
+	value, delta := 7.5, int64(1)
+	mem.SaveMetric(&memstorage.Metrics{ID: "PollCount", MType: "counter", Delta: &delta})
+	mem.SaveMetric(&memstorage.Metrics{ID: "StackInuse", MType: "gauge", Value: &value})
+	mem.SaveMetric(&memstorage.Metrics{ID: "HeapAlloc", MType: "gauge", Value: &value})
+
+	event, _ := reader.ReadString('\n')
+	data, _ := reader.ReadString('\n')
+	if event != "event: gauge\n" || !strings.Contains(data, `"id":"HeapAlloc"`) || !strings.Contains(data, `"value":7.5`) {
+		t.Errorf("got event %q with %q", event, data)
+	}
+	cancel()
+	// после отключения клиента подписка снимается
+	for i := 0; i < 100 && mem.Broker.Len() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if mem.Broker.Len() != 0 {
+		t.Errorf("%d subscribers left after disconnect", mem.Broker.Len())
+	}
+}
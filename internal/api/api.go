@@ -0,0 +1,6 @@
+// Package api содержит общие для сервера и его клиентов константы HTTP API.
+package api
+
+// NextCursorHeader содержит курсор следующей страницы /api/metrics,
+// на последней странице заголовка нет.
+const NextCursorHeader = "X-Next-Cursor"
@@ -0,0 +1,99 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte(`[{"id":"PollCount","type":"counter","delta":5}]`)
+	sealed, err := Seal(&priv.PublicKey, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("PollCount")) {
+		t.Error("Seal() output contains plaintext")
+	}
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	tests := []struct {
+		name    string
+		key     *rsa.PrivateKey
+		data    []byte
+		wantErr bool
+	}{
+		{name: "Test1", key: priv, data: sealed},
+		{name: "Test2", key: other, data: sealed, wantErr: true},
+		{name: "Test3", key: priv, data: tampered, wantErr: true},
+		{name: "Test4", key: priv, data: sealed[:10], wantErr: true},
+		{name: "Test5", key: priv, data: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Open(tt.key, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(got, plaintext) {
+				t.Errorf("Open() = %s, want %s", got, plaintext)
+			}
+		})
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]*pem.Block{
+		"private.pem":  {Type: "PRIVATE KEY", Bytes: privDER},
+		"private1.pem": {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)},
+		"public.pem":   {Type: "PUBLIC KEY", Bytes: pubDER},
+		"public1.pem":  {Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&priv.PublicKey)},
+	}
+	for name, block := range files {
+		if err = os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"private.pem", "private1.pem"} {
+		key, err := LoadPrivateKey(filepath.Join(dir, name))
+		if err != nil || !key.Equal(priv) {
+			t.Errorf("LoadPrivateKey(%s) = %v", name, err)
+		}
+	}
+	for _, name := range []string{"public.pem", "public1.pem"} {
+		key, err := LoadPublicKey(filepath.Join(dir, name))
+		if err != nil || !key.Equal(&priv.PublicKey) {
+			t.Errorf("LoadPublicKey(%s) = %v", name, err)
+		}
+	}
+	if _, err = LoadPublicKey(filepath.Join(dir, "private.pem")); err == nil {
+		t.Error("LoadPublicKey() of a private key should fail")
+	}
+}
@@ -23,9 +23,11 @@ type Consumer struct {
 }
 
 type Metric struct {
-	ID    string `json:"id"`
-	MType string `json:"type"`
-	MVal  string `json:"value"`
+	ID        string                `json:"id"`
+	MType     string                `json:"type"`
+	MVal      string                `json:"value"`
+	Histogram *memstorage.Histogram `json:"histogram,omitempty"`
+	Labels    map[string]string     `json:"labels,omitempty"`
 }
 
 func NewProducer(filename string, trunc bool) (*Producer, error) {
@@ -50,16 +52,27 @@ func NewProducer(filename string, trunc bool) (*Producer, error) {
 func (p *Producer) WriteMemStorage(storage *memstorage.MemStorage) error {
 	counters := storage.GetCounters()
 	gauges := storage.GetGauges()
+	histograms := storage.GetHistograms()
 
 	for k, v := range counters {
-		metric := Metric{ID: k, MType: "counter", MVal: fmt.Sprint(v)}
+		name, labels := memstorage.ParseSeriesKey(k)
+		metric := Metric{ID: name, MType: "counter", MVal: fmt.Sprint(v), Labels: labels}
 		err := p.WriteMetric(&metric)
 		if err != nil {
 			return err
 		}
 	}
 	for k, v := range gauges {
-		metric := Metric{ID: k, MType: "gauge", MVal: fmt.Sprint(v)}
+		name, labels := memstorage.ParseSeriesKey(k)
+		metric := Metric{ID: name, MType: "gauge", MVal: fmt.Sprint(v), Labels: labels}
+		err := p.WriteMetric(&metric)
+		if err != nil {
+			return err
+		}
+	}
+	for k, v := range histograms {
+		name, labels := memstorage.ParseSeriesKey(k)
+		metric := Metric{ID: name, MType: "histogram", Histogram: v, Labels: labels}
 		err := p.WriteMetric(&metric)
 		if err != nil {
 			return err
@@ -91,11 +104,13 @@ func (p *Producer) WriteMetric(metric *Metric) error {
 
 func (p *Producer) WriteMetrics(metrics *[]memstorage.Metrics) error {
 	for _, v := range *metrics {
-		metric := Metric{ID: v.ID, MType: v.MType}
-		if v.Value == nil {
-			metric.MVal = fmt.Sprint(v.Delta)
+		metric := Metric{ID: v.ID, MType: v.MType, Labels: v.Labels}
+		if v.Histogram != nil {
+			metric.Histogram = v.Histogram
+		} else if v.Value == nil {
+			metric.MVal = fmt.Sprint(*v.Delta)
 		} else {
-			metric.MVal = fmt.Sprint(v.Value)
+			metric.MVal = fmt.Sprint(*v.Value)
 		}
 		err := p.WriteMetric(&metric)
 		if err != nil {
@@ -137,14 +152,21 @@ func (c *Consumer) ReadMemStorage() (*memstorage.MemStorage, error) {
 			if err != nil {
 				return memstorage.NewMemStorage(), err
 			}
-			storage.PutCounter(metric.ID, val)
+			// в файле хранятся итоговые значения счётчиков, последняя запись главнее
+			storage.SetCounter(memstorage.SeriesKey(metric.ID, metric.Labels), val)
 		}
 		if metric.MType == "gauge" {
 			val, err := strconv.ParseFloat(metric.MVal, 64)
 			if err != nil {
 				return memstorage.NewMemStorage(), err
 			}
-			storage.PutGauge(metric.ID, val)
+			storage.PutGauge(memstorage.SeriesKey(metric.ID, metric.Labels), val)
+		}
+		if metric.MType == "histogram" && metric.Histogram != nil {
+			if err := metric.Histogram.Validate(); err != nil {
+				return memstorage.NewMemStorage(), err
+			}
+			storage.SetHistogram(memstorage.SeriesKey(metric.ID, metric.Labels), metric.Histogram)
 		}
 	}
 	return storage, nil
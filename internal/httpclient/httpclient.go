@@ -0,0 +1,96 @@
+// Package httpclient собирает HTTP-запросы к серверу метрик так, как их
+// ожидают его обработчики: JSON, сжатый gzip, подписанный HMAC-SHA256 и при
+// необходимости зашифрованный открытым ключом сервера.
+package httpclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/kishenkoilya/metricsalerts/internal/envelope"
+)
+
+const (
+	// SignHeader - заголовок с подписью тела запроса
+	SignHeader = "HashSHA256"
+	// RealIPHeader - заголовок с адресом клиента для проверки доверенной подсети
+	RealIPHeader = "X-Real-IP"
+)
+
+// New создаёт клиента. tlsConfig == nil - HTTP без шифрования, пустой
+// realIP - без заголовка X-Real-IP.
+func New(tlsConfig *tls.Config, realIP string) *resty.Client {
+	client := resty.NewWithClient(&http.Client{
+		Transport: &http.Transport{
+			DisableCompression: true,
+			TLSClientConfig:    tlsConfig,
+		},
+	})
+	if realIP != "" {
+		client.SetHeader(RealIPHeader, realIP)
+	}
+	return client
+}
+
+// OutboundIP возвращает адрес интерфейса, через который идут запросы к address.
+// UDP-сокет ничего не отправляет, а только выбирает маршрут.
+func OutboundIP(address string) (string, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// Sign возвращает HMAC-SHA256 от data в hex.
+func Sign(data []byte, key string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(data)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// JSONGZIPRequest готовит запрос с телом body, например метрикой для /update/
+// или срезом метрик для /updates/. Подпись считается от JSON до сжатия, если
+// key не пустой; при pub != nil сжатое тело шифруется.
+func JSONGZIPRequest(client *resty.Client, body interface{}, key string, pub *rsa.PublicKey) (*resty.Request, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	if _, err = gzipWriter.Write(jsonData); err != nil {
+		return nil, err
+	}
+	if err = gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	request := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Content-Encoding", "gzip").
+		SetHeader("Accept-Encoding", "gzip")
+	if pub != nil {
+		data, err = envelope.Seal(pub, data)
+		if err != nil {
+			return nil, err
+		}
+		request.SetHeader(envelope.Header, envelope.Scheme)
+	}
+	request.SetBody(data)
+	if key != "" {
+		request.SetHeader(SignHeader, Sign(jsonData, key))
+	}
+	return request, nil
+}
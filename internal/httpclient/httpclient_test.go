@@ -0,0 +1,61 @@
+package httpclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"testing"
+
+	"github.com/kishenkoilya/metricsalerts/internal/envelope"
+)
+
+func TestJSONGZIPRequest(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := map[string]string{"id": "PollCount"}
+	tests := []struct {
+		name string
+		key  string
+		pub  *rsa.PublicKey
+	}{
+		{name: "Test1"},
+		{name: "Test2", key: "secret"},
+		{name: "Test3", key: "secret", pub: &priv.PublicKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := JSONGZIPRequest(New(nil, "10.0.0.1"), body, tt.key, tt.pub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := request.Body.([]byte)
+			if tt.pub != nil {
+				if request.Header.Get(envelope.Header) != envelope.Scheme {
+					t.Errorf("%s header is not set", envelope.Header)
+				}
+				if data, err = envelope.Open(priv, data); err != nil {
+					t.Fatal(err)
+				}
+			}
+			reader, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jsonData, _ := io.ReadAll(reader)
+			if string(jsonData) != `{"id":"PollCount"}` {
+				t.Errorf("body = %s", jsonData)
+			}
+			wantSign := ""
+			if tt.key != "" {
+				wantSign = Sign(jsonData, tt.key)
+			}
+			if got := request.Header.Get(SignHeader); got != wantSign {
+				t.Errorf("%s = %q, want %q", SignHeader, got, wantSign)
+			}
+		})
+	}
+}
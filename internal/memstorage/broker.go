@@ -0,0 +1,94 @@
+package memstorage
+
+import "sync"
+
+// Subscription - подписка на изменения метрик. Канал C закрывается при
+// отписке или если подписчик не успевает читать события.
+type Subscription struct {
+	C      <-chan Metrics
+	ch     chan Metrics
+	filter func(*Metrics) bool
+	broker *Broker
+}
+
+// Close отменяет подписку.
+func (s *Subscription) Close() {
+	s.broker.remove(s)
+}
+
+// Broker рассылает подписчикам метрики, изменённые через SaveMetric. Publish
+// никогда не блокируется: подписчик с заполненным буфером отключается.
+type Broker struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	buffer int
+}
+
+// NewBroker создаёт рассыльщик с буфером на buffer событий для каждого подписчика.
+func NewBroker(buffer int) *Broker {
+	return &Broker{subs: make(map[*Subscription]struct{}), buffer: buffer}
+}
+
+// Subscribe подписывает на метрики, для которых filter возвращает true;
+// filter == nil - на все метрики.
+func (b *Broker) Subscribe(filter func(*Metrics) bool) *Subscription {
+	ch := make(chan Metrics, b.buffer)
+	s := &Subscription{C: ch, ch: ch, filter: filter, broker: b}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+func (b *Broker) remove(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// Publish отправляет копию metric подходящим подписчикам.
+func (b *Broker) Publish(metric *Metrics) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.subs) == 0 {
+		return
+	}
+	event := copyMetric(metric)
+	for s := range b.subs {
+		if s.filter != nil && !s.filter(&event) {
+			continue
+		}
+		select {
+		case s.ch <- event:
+		default:
+			delete(b.subs, s)
+			close(s.ch)
+		}
+	}
+}
+
+// Len возвращает число подписчиков.
+func (b *Broker) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+func copyMetric(metric *Metrics) Metrics {
+	res := Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels}
+	if metric.Delta != nil {
+		delta := *metric.Delta
+		res.Delta = &delta
+	}
+	if metric.Value != nil {
+		value := *metric.Value
+		res.Value = &value
+	}
+	if metric.Histogram != nil {
+		res.Histogram = metric.Histogram.Copy()
+	}
+	return res
+}
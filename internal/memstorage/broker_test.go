@@ -0,0 +1,41 @@
+package memstorage
+
+import "testing"
+
+func TestBroker(t *testing.T) {
+	m := NewMemStorage()
+	m.Broker = NewBroker(2)
+	all := m.Broker.Subscribe(nil)
+	gauges := m.Broker.Subscribe(func(metric *Metrics) bool { return metric.MType == "gauge" })
+
+	value, delta := 1.5, int64(2)
+	m.SaveMetric(&Metrics{ID: "Alloc", MType: "gauge", Value: &value})
+	value = 1.5
+	// значение не изменилось, событие не рассылается
+	m.SaveMetric(&Metrics{ID: "Alloc", MType: "gauge", Value: &value})
+	m.SaveMetric(&Metrics{ID: "PollCount", MType: "counter", Delta: &delta})
+
+	got := <-gauges.C
+	if got.ID != "Alloc" || *got.Value != 1.5 {
+		t.Errorf("gauges got %v", got.StringMetric())
+	}
+	if len(gauges.C) != 0 {
+		t.Errorf("gauges has %d extra events", len(gauges.C))
+	}
+	first, second := <-all.C, <-all.C
+	if first.ID != "Alloc" || second.ID != "PollCount" || *second.Delta != 2 {
+		t.Errorf("all got %v, %v", first.StringMetric(), second.StringMetric())
+	}
+
+	// подписчик, который не читает, отключается, а не блокирует запись
+	for i := 0; i < 4; i++ {
+		m.SaveMetric(&Metrics{ID: "PollCount", MType: "counter", Delta: &delta})
+	}
+	n := 0
+	for range all.C {
+		n++
+	}
+	if n != 2 || m.Broker.Len() != 1 {
+		t.Errorf("slow subscriber read %d events, %d subscribers left", n, m.Broker.Len())
+	}
+}
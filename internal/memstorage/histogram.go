@@ -0,0 +1,99 @@
+package memstorage
+
+import (
+	"errors"
+	"sort"
+)
+
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram - гистограмма с фиксированными верхними границами корзин.
+// Counts содержит len(Bounds)+1 значений, последняя корзина - +Inf.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	b := append([]float64(nil), bounds...)
+	sort.Float64s(b)
+	return &Histogram{Bounds: b, Counts: make([]uint64, len(b)+1)}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.Bounds, v)
+	h.Counts[i]++
+	h.Sum += v
+	h.Count++
+}
+
+func (h *Histogram) Validate() error {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return errors.New("histogram must have one count per bound plus +Inf")
+	}
+	if !sort.Float64sAreSorted(h.Bounds) {
+		return errors.New("histogram bounds are not sorted")
+	}
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total != h.Count {
+		return errors.New("histogram count does not match bucket counts")
+	}
+	return nil
+}
+
+func (h *Histogram) sameBounds(other *Histogram) bool {
+	if len(h.Bounds) != len(other.Bounds) {
+		return false
+	}
+	for i := range h.Bounds {
+		if h.Bounds[i] != other.Bounds[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge добавляет к гистограмме значения other. Границы корзин должны совпадать.
+func (h *Histogram) Merge(other *Histogram) error {
+	if !h.sameBounds(other) {
+		return errors.New("histogram bounds do not match")
+	}
+	for i := range h.Counts {
+		h.Counts[i] += other.Counts[i]
+	}
+	h.Sum += other.Sum
+	h.Count += other.Count
+	return nil
+}
+
+// Sub возвращает прирост h относительно более ранней гистограммы other.
+// Границы должны совпадать, а корзины other не должны превышать корзины h.
+func (h *Histogram) Sub(other *Histogram) (*Histogram, error) {
+	if !h.sameBounds(other) {
+		return nil, errors.New("histogram bounds do not match")
+	}
+	res := h.Copy()
+	for i := range res.Counts {
+		if other.Counts[i] > res.Counts[i] {
+			return nil, errors.New("histogram has fewer observations than other")
+		}
+		res.Counts[i] -= other.Counts[i]
+	}
+	res.Sum -= other.Sum
+	res.Count -= other.Count
+	return res, nil
+}
+
+func (h *Histogram) Copy() *Histogram {
+	return &Histogram{
+		Bounds: append([]float64(nil), h.Bounds...),
+		Counts: append([]uint64(nil), h.Counts...),
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+}
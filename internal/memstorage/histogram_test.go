@@ -0,0 +1,96 @@
+package memstorage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHistogram_Observe(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   []uint64
+	}{
+		{name: "Test1", values: nil, want: []uint64{0, 0, 0}},
+		{name: "Test2", values: []float64{0.05, 0.1, 0.5, 7}, want: []uint64{2, 1, 1}},
+		{name: "Test3", values: []float64{100, 200}, want: []uint64{0, 0, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHistogram([]float64{1, 0.1})
+			for _, v := range tt.values {
+				h.Observe(v)
+			}
+			if !reflect.DeepEqual(h.Counts, tt.want) {
+				t.Errorf("Observe() counts = %v, want %v", h.Counts, tt.want)
+			}
+			if h.Count != uint64(len(tt.values)) {
+				t.Errorf("Observe() count = %v, want %v", h.Count, len(tt.values))
+			}
+			if err := h.Validate(); err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	h := NewHistogram([]float64{0.1, 1})
+	h.Observe(0.5)
+	other := NewHistogram([]float64{0.1, 1})
+	other.Observe(0.5)
+	other.Observe(2)
+	if err := h.Merge(other); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if !reflect.DeepEqual(h.Counts, []uint64{0, 2, 1}) || h.Count != 3 || h.Sum != 3 {
+		t.Errorf("Merge() = %+v", h)
+	}
+	if err := h.Merge(NewHistogram([]float64{0.2, 1})); err == nil {
+		t.Error("Merge() with other bounds should fail")
+	}
+}
+
+func TestHistogram_Sub(t *testing.T) {
+	h := NewHistogram([]float64{0.1, 1})
+	h.Observe(0.5)
+	h.Observe(2)
+	earlier := NewHistogram([]float64{0.1, 1})
+	earlier.Observe(0.5)
+	diff, err := h.Sub(earlier)
+	if err != nil {
+		t.Fatalf("Sub() error = %v", err)
+	}
+	if !reflect.DeepEqual(diff.Counts, []uint64{0, 0, 1}) || diff.Count != 1 || diff.Sum != 2 {
+		t.Errorf("Sub() = %+v", diff)
+	}
+	if !reflect.DeepEqual(h.Counts, []uint64{0, 1, 1}) {
+		t.Errorf("Sub() changed h: %+v", h)
+	}
+	if _, err := earlier.Sub(h); err == nil {
+		t.Error("Sub() of a later histogram should fail")
+	}
+	if _, err := h.Sub(NewHistogram([]float64{0.2, 1})); err == nil {
+		t.Error("Sub() with other bounds should fail")
+	}
+}
+
+func TestHistogram_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hist    Histogram
+		wantErr bool
+	}{
+		{name: "Test1", hist: Histogram{Bounds: []float64{1}, Counts: []uint64{1, 2}, Count: 3}},
+		{name: "Test2", hist: Histogram{Bounds: []float64{1}, Counts: []uint64{1}, Count: 1}, wantErr: true},
+		{name: "Test3", hist: Histogram{Bounds: []float64{2, 1}, Counts: []uint64{0, 0, 0}}, wantErr: true},
+		{name: "Test4", hist: Histogram{Bounds: []float64{1}, Counts: []uint64{1, 2}, Count: 5}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.hist.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package memstorage
+
+import (
+	"sync"
+	"time"
+)
+
+// Размер одного сэмпла в памяти: int64 время + float64 значение
+const sampleSize = 16
+
+type Sample struct {
+	Time  time.Time `json:"t"`
+	Value float64   `json:"value"`
+}
+
+type sample struct {
+	ts    int64
+	value float64
+}
+
+// ring - кольцевой буфер сэмплов одной метрики. Буфер растёт по мере
+// необходимости до максимального размера, после чего перезаписывает старые значения.
+type ring struct {
+	buf  []sample
+	head int
+	n    int
+}
+
+func (r *ring) at(i int) sample {
+	return r.buf[(r.head+i)%len(r.buf)]
+}
+
+func (r *ring) popFront() {
+	r.head = (r.head + 1) % len(r.buf)
+	r.n--
+}
+
+// History хранит историю значений метрик с ограничением по времени хранения,
+// по количеству сэмплов на метрику и по общему объёму памяти.
+type History struct {
+	mutex     sync.RWMutex
+	series    map[string]*ring
+	retention time.Duration
+	perSeries int
+	budget    int
+	used      int
+	dropped   int64
+	now       func() time.Time
+}
+
+func NewHistory(retention time.Duration, perSeries int, memoryBudget int) *History {
+	return &History{
+		series:    make(map[string]*ring),
+		retention: retention,
+		perSeries: perSeries,
+		budget:    memoryBudget / sampleSize,
+		now:       time.Now,
+	}
+}
+
+func historyKey(mType, mName string) string {
+	return mType + ":" + mName
+}
+
+func (h *History) Record(mType, mName string, value float64) {
+	now := h.now()
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	key := historyKey(mType, mName)
+	r, ok := h.series[key]
+	if !ok {
+		r = &ring{}
+		h.series[key] = r
+	}
+	h.expire(r, now)
+
+	s := sample{ts: now.UnixNano(), value: value}
+	if r.n < len(r.buf) {
+		r.buf[(r.head+r.n)%len(r.buf)] = s
+		r.n++
+		return
+	}
+	if grow := h.growth(len(r.buf)); grow > 0 {
+		buf := make([]sample, len(r.buf)+grow)
+		for i := 0; i < r.n; i++ {
+			buf[i] = r.at(i)
+		}
+		buf[r.n] = s
+		h.used += grow
+		r.buf, r.head = buf, 0
+		r.n++
+		return
+	}
+	if len(r.buf) == 0 {
+		h.dropped++
+		return
+	}
+	r.buf[r.head] = s
+	r.head = (r.head + 1) % len(r.buf)
+}
+
+// growth возвращает, на сколько сэмплов можно увеличить буфер размера size
+func (h *History) growth(size int) int {
+	grow := size
+	if grow < 8 {
+		grow = 8
+	}
+	if size+grow > h.perSeries {
+		grow = h.perSeries - size
+	}
+	if h.used+grow > h.budget {
+		grow = h.budget - h.used
+	}
+	if grow < 0 {
+		return 0
+	}
+	return grow
+}
+
+func (h *History) expire(r *ring, now time.Time) {
+	deadline := now.Add(-h.retention).UnixNano()
+	for r.n > 0 && r.at(0).ts < deadline {
+		r.popFront()
+	}
+}
+
+// Range возвращает сэмплы за [from, to]. Если step > 0, из каждого интервала
+// длиной step берётся только последнее значение.
+func (h *History) Range(mType, mName string, from, to time.Time, step time.Duration) ([]Sample, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	r, ok := h.series[historyKey(mType, mName)]
+	if !ok {
+		return nil, false
+	}
+	res := make([]Sample, 0)
+	bucket := int64(-1)
+	for i := 0; i < r.n; i++ {
+		s := r.at(i)
+		if s.ts < from.UnixNano() || s.ts > to.UnixNano() {
+			continue
+		}
+		smp := Sample{Time: time.Unix(0, s.ts), Value: s.value}
+		if step > 0 {
+			b := (s.ts - from.UnixNano()) / int64(step)
+			if b == bucket {
+				res[len(res)-1] = smp
+				continue
+			}
+			bucket = b
+		}
+		res = append(res, smp)
+	}
+	return res, true
+}
+
+// Prune удаляет устаревшие сэмплы и освобождает память опустевших метрик.
+func (h *History) Prune() {
+	now := h.now()
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for key, r := range h.series {
+		h.expire(r, now)
+		if r.n == 0 {
+			h.used -= len(r.buf)
+			delete(h.series, key)
+		}
+	}
+}
+
+// Dropped возвращает количество сэмплов, не сохранённых из-за ограничения памяти.
+func (h *History) Dropped() int64 {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.dropped
+}
@@ -0,0 +1,112 @@
+package memstorage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory_Range(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	type args struct {
+		from time.Time
+		to   time.Time
+		step time.Duration
+	}
+	tests := []struct {
+		name      string
+		perSeries int
+		budget    int
+		records   int
+		args      args
+		want      []float64
+	}{
+		{
+			name:      "Test1",
+			perSeries: 100,
+			budget:    1 << 20,
+			records:   5,
+			args:      args{from: start, to: start.Add(time.Hour)},
+			want:      []float64{0, 1, 2, 3, 4},
+		},
+		{
+			name:      "Test2",
+			perSeries: 3,
+			budget:    1 << 20,
+			records:   5,
+			args:      args{from: start, to: start.Add(time.Hour)},
+			want:      []float64{2, 3, 4},
+		},
+		{
+			name:      "Test3",
+			perSeries: 100,
+			budget:    1 << 20,
+			records:   6,
+			args:      args{from: start.Add(time.Minute), to: start.Add(4 * time.Minute)},
+			want:      []float64{1, 2, 3, 4},
+		},
+		{
+			name:      "Test4",
+			perSeries: 100,
+			budget:    1 << 20,
+			records:   6,
+			args:      args{from: start, to: start.Add(time.Hour), step: 2 * time.Minute},
+			want:      []float64{1, 3, 5},
+		},
+		{
+			name:      "Test5",
+			perSeries: 100,
+			budget:    2 * sampleSize,
+			records:   5,
+			args:      args{from: start, to: start.Add(time.Hour)},
+			want:      []float64{3, 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHistory(24*time.Hour, tt.perSeries, tt.budget)
+			now := start
+			h.now = func() time.Time { return now }
+			for i := 0; i < tt.records; i++ {
+				now = start.Add(time.Duration(i) * time.Minute)
+				h.Record("gauge", "HeapAlloc", float64(i))
+			}
+			got, ok := h.Range("gauge", "HeapAlloc", tt.args.from, tt.args.to, tt.args.step)
+			if !ok {
+				t.Fatalf("History.Range() series not found")
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("History.Range() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].Value != tt.want[i] {
+					t.Errorf("History.Range()[%d] = %v, want %v", i, got[i].Value, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHistory_Prune(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := NewHistory(time.Minute, 10, 10*sampleSize)
+	now := start
+	h.now = func() time.Time { return now }
+	h.Record("gauge", "Alloc", 1)
+	h.Record("counter", "PollCount", 1)
+
+	// память исчерпана: новая метрика не помещается
+	h.Record("gauge", "Sys", 1)
+	if h.Dropped() != 1 {
+		t.Errorf("History.Dropped() = %v, want 1", h.Dropped())
+	}
+
+	now = start.Add(2 * time.Minute)
+	h.Prune()
+	if _, ok := h.Range("gauge", "Alloc", start, now, 0); ok {
+		t.Errorf("History.Range() found expired series")
+	}
+	h.Record("gauge", "Sys", 2)
+	if got, ok := h.Range("gauge", "Sys", start, now, 0); !ok || len(got) != 1 {
+		t.Errorf("History.Range() = %v, want one sample after prune", got)
+	}
+}
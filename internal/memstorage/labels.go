@@ -0,0 +1,82 @@
+package memstorage
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FormatLabels возвращает каноническое представление меток: пары key="value",
+// отсортированные по ключу и разделённые запятыми.
+func FormatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[k]))
+	}
+	return b.String()
+}
+
+// ParseLabels разбирает строку, полученную из FormatLabels.
+func ParseLabels(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, errors.New("bad labels: missing key")
+		}
+		key := s[:eq]
+		quoted, err := strconv.QuotedPrefix(s[eq+1:])
+		if err != nil {
+			return nil, errors.New("bad labels: " + err.Error())
+		}
+		val, _ := strconv.Unquote(quoted)
+		labels[key] = val
+		s = s[eq+1+len(quoted):]
+		if s != "" {
+			if s[0] != ',' {
+				return nil, errors.New("bad labels: missing separator")
+			}
+			s = s[1:]
+		}
+	}
+	return labels, nil
+}
+
+// SeriesKey возвращает ключ, под которым метрика хранится в MemStorage.
+// Для метрик без меток ключ совпадает с именем.
+func SeriesKey(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	return name + "{" + FormatLabels(labels) + "}"
+}
+
+// ParseSeriesKey разбирает ключ, полученный из SeriesKey.
+func ParseSeriesKey(key string) (string, map[string]string) {
+	start := strings.IndexByte(key, '{')
+	if start < 0 || !strings.HasSuffix(key, "}") {
+		return key, nil
+	}
+	labels, err := ParseLabels(key[start+1 : len(key)-1])
+	if err != nil {
+		return key, nil
+	}
+	return key[:start], labels
+}
@@ -0,0 +1,70 @@
+package memstorage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSeriesKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		mName  string
+		labels map[string]string
+		want   string
+	}{
+		{name: "Test1", mName: "Alloc", want: "Alloc"},
+		{name: "Test2", mName: "Alloc", labels: map[string]string{"host": "b", "dc": "eu"}, want: `Alloc{dc="eu",host="b"}`},
+		{name: "Test3", mName: "Alloc", labels: map[string]string{"host": `a",b`}, want: `Alloc{host="a\",b"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SeriesKey(tt.mName, tt.labels)
+			if got != tt.want {
+				t.Errorf("SeriesKey() = %v, want %v", got, tt.want)
+			}
+			name, labels := ParseSeriesKey(got)
+			if name != tt.mName || !reflect.DeepEqual(labels, tt.labels) {
+				t.Errorf("ParseSeriesKey() = %v, %v, want %v, %v", name, labels, tt.mName, tt.labels)
+			}
+		})
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantErr bool
+	}{
+		{name: "Test1", s: `host="a"`},
+		{name: "Test2", s: `host=a`, wantErr: true},
+		{name: "Test3", s: `="a"`, wantErr: true},
+		{name: "Test4", s: `host="a"dc="b"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseLabels(tt.s); (err != nil) != tt.wantErr {
+				t.Errorf("ParseLabels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemStorage_SaveMetricsLabels(t *testing.T) {
+	m := NewMemStorage()
+	alloc1, alloc2 := 1.0, 2.0
+	status, res := m.SaveMetrics(&[]Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &alloc1, Labels: map[string]string{"host": "a"}},
+		{ID: "Alloc", MType: "gauge", Value: &alloc2, Labels: map[string]string{"host": "b"}},
+	})
+	if status != 200 || len(*res) != 2 {
+		t.Fatalf("MemStorage.SaveMetrics() = %v, %v", status, res)
+	}
+	status, got := m.GetLabeledMetrics("gauge", "Alloc", map[string]string{"host": "a"})
+	if status != 200 || *got.Value != 1 {
+		t.Errorf("MemStorage.GetLabeledMetrics() = %v, %v, want 1", status, got)
+	}
+	if status, _ = m.GetMetrics("gauge", "Alloc"); status != 404 {
+		t.Errorf("MemStorage.GetMetrics() status = %v, want 404", status)
+	}
+}
@@ -1,6 +1,7 @@
 package memstorage
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -11,13 +12,21 @@ type MemStorage struct {
 	Mutex    sync.RWMutex
 	Counters map[string]int64
 	Gauges   map[string]float64
+	// Гистограммы, создаются при первой записи
+	Histograms map[string]*Histogram
+	// История значений, nil если не включена
+	History *History
+	// Рассылка изменений через SaveMetric, nil если не включена
+	Broker *Broker
 }
 
 type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID        string            `json:"id"`                  // имя метрики
+	MType     string            `json:"type"`                // параметр, принимающий значение gauge, counter или histogram
+	Delta     *int64            `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Value     *float64          `json:"value,omitempty"`     // значение метрики в случае передачи gauge
+	Histogram *Histogram        `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
+	Labels    map[string]string `json:"labels,omitempty"`    // метки, метрики с разными метками хранятся раздельно
 }
 
 func NewMetric(mType, mName, mVal string) *Metrics {
@@ -44,73 +53,129 @@ func NewMetric(mType, mName, mVal string) *Metrics {
 }
 
 func (m *Metrics) PrintMetric() {
-	if m.Delta != nil {
-		fmt.Println("ID: " + m.ID + "; MType: " + m.MType + "; Delta: " + fmt.Sprint(*m.Delta) + "; Value:" + fmt.Sprint(m.Value))
-	} else if m.Value != nil {
-		fmt.Println("ID: " + m.ID + "; MType: " + m.MType + "; Delta: " + fmt.Sprint(m.Delta) + "; Value:" + fmt.Sprint(*m.Value))
-	} else {
-		fmt.Println("ID: " + m.ID + "; MType: " + m.MType + "; Delta: " + fmt.Sprint(m.Delta) + "; Value:" + fmt.Sprint(m.Value))
-	}
+	fmt.Println(m.StringMetric())
 }
 
 func (m *Metrics) StringMetric() string {
-	if m.Delta != nil {
-		return "ID: " + m.ID + "; MType: " + m.MType + "; Delta: " + fmt.Sprint(*m.Delta) + "; Value:" + fmt.Sprint(m.Value)
+	id := SeriesKey(m.ID, m.Labels)
+	if m.Histogram != nil {
+		return "ID: " + id + "; MType: " + m.MType + "; Count: " + fmt.Sprint(m.Histogram.Count) + "; Sum:" + fmt.Sprint(m.Histogram.Sum)
+	} else if m.Delta != nil {
+		return "ID: " + id + "; MType: " + m.MType + "; Delta: " + fmt.Sprint(*m.Delta) + "; Value:" + fmt.Sprint(m.Value)
 	} else if m.Value != nil {
-		return "ID: " + m.ID + "; MType: " + m.MType + "; Delta: " + fmt.Sprint(m.Delta) + "; Value:" + fmt.Sprint(*m.Value)
+		return "ID: " + id + "; MType: " + m.MType + "; Delta: " + fmt.Sprint(m.Delta) + "; Value:" + fmt.Sprint(*m.Value)
 	} else {
-		return "ID: " + m.ID + "; MType: " + m.MType + "; Delta: " + fmt.Sprint(m.Delta) + "; Value:" + fmt.Sprint(m.Value)
+		return "ID: " + id + "; MType: " + m.MType + "; Delta: " + fmt.Sprint(m.Delta) + "; Value:" + fmt.Sprint(m.Value)
 	}
 }
 
 func (m *MemStorage) SaveMetric(metric *Metrics) (int, *Metrics) {
 	metric.PrintMetric()
+	key := SeriesKey(metric.ID, metric.Labels)
+	// изменилось ли значение, только такие изменения рассылаются подписчикам
+	changed := true
 	if metric.MType == "gauge" {
-		m.PutGauge(metric.ID, *metric.Value)
-		val, ok := m.GetGauge(metric.ID)
+		old, existed := m.GetGauge(key)
+		changed = !existed || old != *metric.Value
+		m.PutGauge(key, *metric.Value)
+		val, ok := m.GetGauge(key)
 		if ok {
 			*metric.Value = val
 		}
 	} else if metric.MType == "counter" {
-		m.PutCounter(metric.ID, *metric.Delta)
-		val, ok := m.GetCounter(metric.ID)
+		_, existed := m.GetCounter(key)
+		changed = !existed || *metric.Delta != 0
+		m.PutCounter(key, *metric.Delta)
+		val, ok := m.GetCounter(key)
 		if ok {
 			*metric.Delta = val
 		}
+	} else if metric.MType == "histogram" {
+		_, existed := m.GetHistogram(key)
+		changed = !existed || metric.Histogram.Count != 0
+		err := m.PutHistogram(key, metric.Histogram)
+		if err != nil {
+			return http.StatusBadRequest, metric
+		}
+		metric.Histogram, _ = m.GetHistogram(key)
 	} else {
 		return http.StatusBadRequest, metric
 	}
+	if m.Broker != nil && changed {
+		m.Broker.Publish(metric)
+	}
 	return http.StatusOK, metric
 }
 
+// checkMetrics проверяет, что каждую метрику пачки можно сохранить, в том
+// числе что границы гистограмм совпадают с уже сохранёнными и между собой.
+func (m *MemStorage) checkMetrics(metrics []Metrics) bool {
+	m.Mutex.RLock()
+	defer m.Mutex.RUnlock()
+	histograms := make(map[string]*Histogram)
+	for i := range metrics {
+		metric := &metrics[i]
+		switch metric.MType {
+		case "gauge":
+			if metric.Value == nil {
+				return false
+			}
+		case "counter":
+			if metric.Delta == nil {
+				return false
+			}
+		case "histogram":
+			if metric.Histogram == nil || metric.Histogram.Validate() != nil {
+				return false
+			}
+			key := SeriesKey(metric.ID, metric.Labels)
+			cur, ok := histograms[key]
+			if !ok {
+				cur, ok = m.Histograms[key]
+			}
+			if ok && !cur.sameBounds(metric.Histogram) {
+				return false
+			}
+			histograms[key] = metric.Histogram
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// SaveMetrics сохраняет пачку метрик. Пачка проверяется целиком до первого
+// изменения, так что ошибка в одной метрике не оставляет остальные применёнными.
 func (m *MemStorage) SaveMetrics(metrics *[]Metrics) (int, *[]Metrics) {
+	if !m.checkMetrics(*metrics) {
+		return http.StatusBadRequest, nil
+	}
 	status := http.StatusOK
-	results := make(map[string]string)
+	results := make(map[string]Metrics)
 	for _, metric := range *metrics {
 		status, _ = m.SaveMetric(&metric)
 		if status != http.StatusOK {
 			return status, nil
 		}
-		results[metric.ID] = metric.MType
+		results[metric.MType+":"+SeriesKey(metric.ID, metric.Labels)] = Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels}
 	}
 	newMetrics := make([]Metrics, len(results))
 	iter := 0
-	for k, v := range results {
-		if v == "gauge" {
-			val, _ := m.GetGauge(k)
-			newMetrics[iter] = Metrics{
-				ID:    k,
-				MType: "gauge",
-				Value: &val,
-			}
+	for _, v := range results {
+		key := SeriesKey(v.ID, v.Labels)
+		if v.MType == "gauge" {
+			val, _ := m.GetGauge(key)
+			v.Value = &val
+			newMetrics[iter] = v
 			iter++
-		} else if v == "counter" {
-			val, _ := m.GetCounter(k)
-			newMetrics[iter] = Metrics{
-				ID:    k,
-				MType: "counter",
-				Delta: &val,
-			}
+		} else if v.MType == "counter" {
+			val, _ := m.GetCounter(key)
+			v.Delta = &val
+			newMetrics[iter] = v
+			iter++
+		} else if v.MType == "histogram" {
+			v.Histogram, _ = m.GetHistogram(key)
+			newMetrics[iter] = v
 			iter++
 		}
 	}
@@ -118,23 +183,36 @@ func (m *MemStorage) SaveMetrics(metrics *[]Metrics) (int, *[]Metrics) {
 }
 
 func (m *MemStorage) GetMetrics(mType, mName string) (int, *Metrics) {
+	return m.GetLabeledMetrics(mType, mName, nil)
+}
+
+func (m *MemStorage) GetLabeledMetrics(mType, mName string, labels map[string]string) (int, *Metrics) {
 	var res Metrics
 	res.ID = mName
 	res.MType = mType
+	res.Labels = labels
+	key := SeriesKey(mName, labels)
 	if mType == "gauge" {
-		val, ok := m.GetGauge(mName)
+		val, ok := m.GetGauge(key)
 		if ok {
 			res.Value = &val
 		} else {
 			return http.StatusNotFound, &res
 		}
 	} else if mType == "counter" {
-		del, ok := m.GetCounter(mName)
+		del, ok := m.GetCounter(key)
 		if ok {
 			res.Delta = &del
 		} else {
 			return http.StatusNotFound, &res
 		}
+	} else if mType == "histogram" {
+		hist, ok := m.GetHistogram(key)
+		if ok {
+			res.Histogram = hist
+		} else {
+			return http.StatusNotFound, &res
+		}
 	}
 	return http.StatusOK, &res
 }
@@ -146,13 +224,136 @@ func NewMemStorage() *MemStorage {
 func (m *MemStorage) PutCounter(nameC string, value int64) {
 	m.Mutex.Lock()
 	m.Counters[nameC] += value
+	res := m.Counters[nameC]
+	m.Mutex.Unlock()
+	if m.History != nil {
+		m.History.Record("counter", nameC, float64(res))
+	}
+}
+
+func (m *MemStorage) SetCounter(nameC string, value int64) {
+	m.Mutex.Lock()
+	m.Counters[nameC] = value
+	m.Mutex.Unlock()
+	if m.History != nil {
+		m.History.Record("counter", nameC, float64(value))
+	}
+}
+
+// Load перезаписывает значения метрик значениями из other.
+func (m *MemStorage) Load(other *MemStorage) {
+	counters := other.GetCounters()
+	gauges := other.GetGauges()
+	histograms := other.GetHistograms()
+	m.Mutex.Lock()
+	for k, v := range counters {
+		m.Counters[k] = v
+	}
+	for k, v := range gauges {
+		m.Gauges[k] = v
+	}
+	for k, v := range histograms {
+		if m.Histograms == nil {
+			m.Histograms = make(map[string]*Histogram)
+		}
+		m.Histograms[k] = v
+	}
+	m.Mutex.Unlock()
+}
+
+// PutHistogram добавляет значения hist к гистограмме nameH.
+func (m *MemStorage) PutHistogram(nameH string, hist *Histogram) error {
+	if hist == nil {
+		return errors.New("empty histogram")
+	}
+	if err := hist.Validate(); err != nil {
+		return err
+	}
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	if m.Histograms == nil {
+		m.Histograms = make(map[string]*Histogram)
+	}
+	cur, ok := m.Histograms[nameH]
+	if !ok {
+		m.Histograms[nameH] = hist.Copy()
+		return nil
+	}
+	return cur.Merge(hist)
+}
+
+func (m *MemStorage) SetHistogram(nameH string, hist *Histogram) {
+	m.Mutex.Lock()
+	if m.Histograms == nil {
+		m.Histograms = make(map[string]*Histogram)
+	}
+	m.Histograms[nameH] = hist.Copy()
 	m.Mutex.Unlock()
 }
 
+// ObserveHistogram добавляет одно наблюдение, создавая гистограмму с bounds при необходимости.
+func (m *MemStorage) ObserveHistogram(nameH string, bounds []float64, value float64) {
+	m.Mutex.Lock()
+	if m.Histograms == nil {
+		m.Histograms = make(map[string]*Histogram)
+	}
+	hist, ok := m.Histograms[nameH]
+	if !ok {
+		hist = NewHistogram(bounds)
+		m.Histograms[nameH] = hist
+	}
+	hist.Observe(value)
+	m.Mutex.Unlock()
+}
+
+func (m *MemStorage) GetHistogram(nameH string) (*Histogram, bool) {
+	m.Mutex.RLock()
+	defer m.Mutex.RUnlock()
+	hist, ok := m.Histograms[nameH]
+	if !ok {
+		return nil, false
+	}
+	return hist.Copy(), true
+}
+
+func (m *MemStorage) GetHistograms() map[string]*Histogram {
+	m.Mutex.RLock()
+	result := make(map[string]*Histogram)
+	for k, v := range m.Histograms {
+		result[k] = v.Copy()
+	}
+	m.Mutex.RUnlock()
+	return result
+}
+
+// TakeCounters возвращает все счётчики и обнуляет их.
+func (m *MemStorage) TakeCounters() map[string]int64 {
+	m.Mutex.Lock()
+	result := m.Counters
+	m.Counters = make(map[string]int64)
+	m.Mutex.Unlock()
+	return result
+}
+
+// TakeHistograms возвращает все гистограммы и очищает их.
+func (m *MemStorage) TakeHistograms() map[string]*Histogram {
+	m.Mutex.Lock()
+	result := m.Histograms
+	m.Histograms = nil
+	m.Mutex.Unlock()
+	if result == nil {
+		result = make(map[string]*Histogram)
+	}
+	return result
+}
+
 func (m *MemStorage) PutGauge(nameG string, value float64) {
 	m.Mutex.Lock()
 	m.Gauges[nameG] = value
 	m.Mutex.Unlock()
+	if m.History != nil {
+		m.History.Record("gauge", nameG, value)
+	}
 }
 
 func (m *MemStorage) GetCounter(nameC string) (int64, bool) {
@@ -205,6 +406,12 @@ func (m *MemStorage) PrintAll() string {
 	for k, v := range m.Gauges {
 		res += k + ": " + fmt.Sprint(v) + "\n"
 	}
+	if len(m.Histograms) > 0 {
+		res += "Histograms:\n"
+	}
+	for k, v := range m.Histograms {
+		res += k + ": count " + fmt.Sprint(v.Count) + ", sum " + fmt.Sprint(v.Sum) + "\n"
+	}
 	m.Mutex.RUnlock()
 	return res
 }
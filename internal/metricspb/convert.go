@@ -0,0 +1,55 @@
+// Package metricspb содержит gRPC API сервера метрик.
+package metricspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative metrics.proto
+
+import "github.com/kishenkoilya/metricsalerts/internal/memstorage"
+
+// FromMetrics переводит memstorage.Metrics в сообщение Metric.
+func FromMetrics(m memstorage.Metrics) *Metric {
+	res := &Metric{
+		Id:     m.ID,
+		Type:   m.MType,
+		Delta:  m.Delta,
+		Value:  m.Value,
+		Labels: m.Labels,
+	}
+	if m.Histogram != nil {
+		res.Histogram = &Histogram{
+			Bounds: m.Histogram.Bounds,
+			Counts: m.Histogram.Counts,
+			Sum:    m.Histogram.Sum,
+			Count:  m.Histogram.Count,
+		}
+	}
+	return res
+}
+
+// FromMetricsSlice переводит срез memstorage.Metrics в срез сообщений Metric.
+func FromMetricsSlice(metrics []memstorage.Metrics) []*Metric {
+	res := make([]*Metric, 0, len(metrics))
+	for _, m := range metrics {
+		res = append(res, FromMetrics(m))
+	}
+	return res
+}
+
+// ToMetrics переводит сообщение Metric в memstorage.Metrics.
+func (x *Metric) ToMetrics() memstorage.Metrics {
+	res := memstorage.Metrics{
+		ID:     x.GetId(),
+		MType:  x.GetType(),
+		Delta:  x.Delta,
+		Value:  x.Value,
+		Labels: x.GetLabels(),
+	}
+	if h := x.GetHistogram(); h != nil {
+		res.Histogram = &memstorage.Histogram{
+			Bounds: h.GetBounds(),
+			Counts: h.GetCounts(),
+			Sum:    h.GetSum(),
+			Count:  h.GetCount(),
+		}
+	}
+	return res
+}
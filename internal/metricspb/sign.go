@@ -0,0 +1,26 @@
+package metricspb
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Ключи метаданных gRPC, аналогичные HTTP-заголовкам HashSHA256 и X-Real-IP
+const (
+	SignKey   = "hashsha256"
+	RealIPKey = "x-real-ip"
+)
+
+// Sign возвращает HMAC-SHA256 сообщения msg в детерминированной сериализации.
+func Sign(msg proto.Message, key string) (string, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(data)
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
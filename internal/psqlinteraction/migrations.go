@@ -0,0 +1,100 @@
+package psqlinteraction
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrationLockID - ключ advisory lock, чтобы несколько серверов
+// не применяли миграции одновременно
+const migrationLockID = 7345100
+
+// migrations применяются по порядку, номер версии - индекс + 1.
+// Уже выпущенные миграции менять нельзя, только добавлять новые.
+var migrations = []string{
+	// 1: исходная схема - каждая запись добавляет строку в таблицу своего типа
+	`CREATE TABLE IF NOT EXISTS gauges (id SERIAL PRIMARY KEY, name VARCHAR(50), value double precision);
+	CREATE TABLE IF NOT EXISTS counters (id SERIAL PRIMARY KEY, name VARCHAR(50), value bigint);
+	ALTER TABLE gauges ADD COLUMN IF NOT EXISTS labels TEXT NOT NULL DEFAULT '';
+	ALTER TABLE counters ADD COLUMN IF NOT EXISTS labels TEXT NOT NULL DEFAULT '';
+	CREATE TABLE IF NOT EXISTS histograms (id SERIAL PRIMARY KEY, name VARCHAR(50), labels TEXT NOT NULL DEFAULT '',
+		bounds double precision[], counts bigint[], sum double precision, count bigint);`,
+
+	// 2: одна строка на метрику, обновляется через INSERT ... ON CONFLICT.
+	// Последние значения переносятся из старых таблиц, сами таблицы удаляются.
+	`CREATE TABLE metrics (
+		type TEXT NOT NULL,
+		name TEXT NOT NULL,
+		labels TEXT NOT NULL DEFAULT '',
+		value double precision,
+		delta bigint,
+		bounds double precision[],
+		counts bigint[],
+		sum double precision,
+		count bigint,
+		updated_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (type, name, labels)
+	);
+	INSERT INTO metrics (type, name, labels, value)
+		SELECT DISTINCT ON (name, labels) 'gauge', name, labels, value FROM gauges ORDER BY name, labels, id DESC;
+	INSERT INTO metrics (type, name, labels, delta)
+		SELECT DISTINCT ON (name, labels) 'counter', name, labels, value FROM counters ORDER BY name, labels, id DESC;
+	INSERT INTO metrics (type, name, labels, bounds, counts, sum, count)
+		SELECT DISTINCT ON (name, labels) 'histogram', name, labels, bounds, counts, sum, count FROM histograms ORDER BY name, labels, id DESC;
+	DROP TABLE gauges, counters, histograms;`,
+
+	// 3: необязательная история значений, очищается по сроку хранения
+	`CREATE TABLE samples (
+		type TEXT NOT NULL,
+		name TEXT NOT NULL,
+		labels TEXT NOT NULL DEFAULT '',
+		value double precision NOT NULL,
+		ts timestamptz NOT NULL DEFAULT now()
+	);
+	CREATE INDEX samples_series_ts ON samples (type, name, labels, ts);
+	CREATE INDEX samples_ts ON samples (ts);`,
+
+	// 4: версия записи, чтобы запоздавшая транзакция не затирала более новое значение
+	`ALTER TABLE metrics ADD COLUMN version bigint NOT NULL DEFAULT 0;`,
+}
+
+// migrate применяет недостающие миграции, каждую в своей транзакции.
+func migrate(ctx context.Context, pool *pgxpool.Pool) error {
+	_, err := pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version integer PRIMARY KEY, applied_at timestamptz NOT NULL DEFAULT now());`)
+	if err != nil {
+		return err
+	}
+	for i, query := range migrations {
+		version := i + 1
+		if err = applyMigration(ctx, pool, version, query); err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, pool *pgxpool.Pool, version int, query string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+		return err
+	}
+	var applied bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", version).Scan(&applied)
+	if err != nil || applied {
+		return err
+	}
+	if _, err = tx.Exec(ctx, query); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+		return err
+	}
+	fmt.Println("Applied migration", version)
+	return tx.Commit(ctx)
+}
@@ -1,202 +1,258 @@
 package psqlinteraction
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"time"
 
-	"github.com/jackc/pgx"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/retry"
 )
 
 type RetryFunc func() (interface{}, error)
 
-func PingPSQL(psqlLine string) RetryFunc {
-	return func() (interface{}, error) {
-		connConfig, err := pgx.ParseConnectionString(psqlLine)
-		if err != nil {
-			return nil, err
-		}
-		db, err := pgx.Connect(connConfig)
-		if err != nil {
-			return nil, err
-		}
-		defer db.Close()
-		return nil, nil
+// RetryPolicy - политика повторов для всех обращений к базе.
+var RetryPolicy = retry.Policy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Second,
+	MaxDelay:    5 * time.Second,
+	Multiplier:  2,
+	Jitter:      0.2,
+	Retryable:   Retryable,
+}
+
+// Retry выполняет f по RetryPolicy, пока не отменён ctx.
+func Retry(ctx context.Context, f RetryFunc) (interface{}, error) {
+	var result interface{}
+	err := RetryPolicy.Do(ctx, func() error {
+		var err error
+		result, err = f()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Retryable сообщает, может ли повтор помочь: сюда относятся проблемы
+// с соединением, остановка или перезапуск сервера и конфликты транзакций.
+func Retryable(err error) bool {
+	var pgerr *pgconn.PgError
+	if errors.As(err, &pgerr) {
+		return pgerrcode.IsConnectionException(pgerr.Code) ||
+			pgerrcode.IsOperatorIntervention(pgerr.Code) ||
+			pgerrcode.IsTransactionRollback(pgerr.Code)
 	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || pgconn.SafeToRetry(err)
+}
+
+// PoolConfig - настройки пула соединений. Нулевые значения оставляют настройки pgxpool по умолчанию.
+type PoolConfig struct {
+	MaxConns int32
+	MinConns int32
+	// размер кэша подготовленных выражений на одно соединение
+	StatementCacheCapacity int
 }
 
+// DBConnection - пул соединений с Postgres, безопасен для одновременного
+// использования из нескольких обработчиков.
 type DBConnection struct {
-	conn *pgx.Conn
+	pool *pgxpool.Pool
+	// писать ли каждое значение gauge и counter ещё и в таблицу samples
+	samples bool
 }
 
-func NewDBConnection(psqlLine string) RetryFunc {
+func NewDBConnection(ctx context.Context, psqlLine string, poolConfig PoolConfig) RetryFunc {
 	return func() (interface{}, error) {
-		connConfig, err := pgx.ParseConnectionString(psqlLine)
+		config, err := pgxpool.ParseConfig(psqlLine)
 		if err != nil {
 			return nil, err
 		}
-		db, err := pgx.Connect(connConfig)
+		if poolConfig.MaxConns > 0 {
+			config.MaxConns = poolConfig.MaxConns
+		}
+		if poolConfig.MinConns > 0 {
+			config.MinConns = poolConfig.MinConns
+		}
+		if poolConfig.StatementCacheCapacity > 0 {
+			config.ConnConfig.StatementCacheCapacity = poolConfig.StatementCacheCapacity
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, config)
 		if err != nil {
 			return nil, err
 		}
-		return &DBConnection{db}, nil
+		// пул подключается лениво, проверяем соединение сразу
+		if err = pool.Ping(ctx); err != nil {
+			pool.Close()
+			return nil, err
+		}
+		return &DBConnection{pool: pool}, nil
 	}
 }
 
 func (db *DBConnection) Close() error {
-	return db.conn.Close()
+	db.pool.Close()
+	return nil
 }
 
-func (db *DBConnection) InitTables() RetryFunc {
+// RecordSamples включает запись истории значений в таблицу samples.
+func (db *DBConnection) RecordSamples(enabled bool) {
+	db.samples = enabled
+}
+
+func (db *DBConnection) Ping(ctx context.Context) RetryFunc {
 	return func() (interface{}, error) {
-		query := `CREATE TABLE IF NOT EXISTS gauges (id SERIAL PRIMARY KEY, name VARCHAR(50), value double precision);`
-		res, err := db.conn.Exec(query)
-		if err != nil {
-			return nil, err
-		}
-		fmt.Println(res)
-		query = `CREATE TABLE IF NOT EXISTS counters (id SERIAL PRIMARY KEY, name VARCHAR(50), value bigint);`
-		res, err = db.conn.Exec(query)
-		if err != nil {
-			return nil, err
-		}
-		fmt.Println(res)
-		return nil, nil
+		return nil, db.pool.Ping(ctx)
 	}
 }
 
-func (db *DBConnection) WriteMemStorage(storage *memstorage.MemStorage) RetryFunc {
+// InitTables создаёт схему или обновляет её до последней версии.
+func (db *DBConnection) InitTables(ctx context.Context) RetryFunc {
 	return func() (interface{}, error) {
-		tx, err := db.conn.Begin()
-		if err != nil {
-			return nil, err
+		return nil, migrate(ctx, db.pool)
+	}
+}
+
+const upsertMetricQuery = `INSERT INTO metrics (type, name, labels, value, delta, bounds, counts, sum, count, updated_at, version)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, now(), $10)
+	ON CONFLICT (type, name, labels) DO UPDATE SET
+		value = EXCLUDED.value, delta = EXCLUDED.delta, bounds = EXCLUDED.bounds, counts = EXCLUDED.counts,
+		sum = EXCLUDED.sum, count = EXCLUDED.count, updated_at = EXCLUDED.updated_at, version = EXCLUDED.version
+	WHERE metrics.version < EXCLUDED.version`
+
+const insertSampleQuery = "INSERT INTO samples (type, name, labels, value) VALUES ($1, $2, $3, $4)"
+
+// upsertMetric записывает текущее значение метрики. Значение счётчика - итоговое,
+// а не приращение: источником истины остаётся MemStorage. Строка обновляется,
+// только если version больше записанной, поэтому транзакции, завершившиеся не
+// по порядку, не откатывают значение назад.
+func (db *DBConnection) upsertMetric(ctx context.Context, tx pgx.Tx, metric memstorage.Metrics, version int64) error {
+	labels := memstorage.FormatLabels(metric.Labels)
+	var (
+		bounds []float64
+		counts []int64
+		sum    *float64
+		count  *int64
+	)
+	if hist := metric.Histogram; hist != nil {
+		bounds = hist.Bounds
+		counts = make([]int64, len(hist.Counts))
+		for i, c := range hist.Counts {
+			counts[i] = int64(c)
 		}
-		counters := storage.GetCounters()
-		gauges := storage.GetGauges()
+		c := int64(hist.Count)
+		sum, count = &hist.Sum, &c
+	}
+	_, err := tx.Exec(ctx, upsertMetricQuery, metric.MType, metric.ID, labels, metric.Value, metric.Delta, bounds, counts, sum, count, version)
+	if err != nil || !db.samples {
+		return err
+	}
+	var value float64
+	switch {
+	case metric.Value != nil:
+		value = *metric.Value
+	case metric.Delta != nil:
+		value = float64(*metric.Delta)
+	default:
+		return nil
+	}
+	_, err = tx.Exec(ctx, insertSampleQuery, metric.MType, metric.ID, labels, value)
+	return err
+}
 
-		for k, v := range counters {
-			_, err := tx.Exec("INSERT INTO counters (name, value) VALUES($1,$2)", k, v)
-			if err != nil {
-				tx.Rollback()
-				return nil, err
+// WriteMetrics записывает значения метрик с версией version. Версии должны
+// расти в том же порядке, в котором менялись значения в памяти.
+func (db *DBConnection) WriteMetrics(ctx context.Context, metrics *[]memstorage.Metrics, version int64) RetryFunc {
+	return func() (interface{}, error) {
+		// одинаковый порядок строк в транзакциях исключает взаимные блокировки
+		sorted := append([]memstorage.Metrics(nil), *metrics...)
+		sort.Slice(sorted, func(i, j int) bool {
+			if sorted[i].MType != sorted[j].MType {
+				return sorted[i].MType < sorted[j].MType
 			}
+			return memstorage.SeriesKey(sorted[i].ID, sorted[i].Labels) < memstorage.SeriesKey(sorted[j].ID, sorted[j].Labels)
+		})
+		tx, err := db.pool.Begin(ctx)
+		if err != nil {
+			return nil, err
 		}
-		for k, v := range gauges {
-			_, err := tx.Exec("INSERT INTO gauges (name, value) VALUES($1,$2)", k, v)
-			if err != nil {
-				tx.Rollback()
+		for _, v := range sorted {
+			if err := db.upsertMetric(ctx, tx, v, version); err != nil {
+				tx.Rollback(ctx)
 				return nil, err
 			}
 		}
-		err = tx.Commit()
-		if err != nil {
-			db.Close()
-			return nil, err
-		}
-		db.Close()
-		return nil, nil
+		return nil, tx.Commit(ctx)
 	}
 }
 
-func (db *DBConnection) WriteMetric(mType, mName, mVal string) RetryFunc {
+// MaxVersion возвращает наибольшую записанную версию.
+func (db *DBConnection) MaxVersion(ctx context.Context) RetryFunc {
 	return func() (interface{}, error) {
-		var query string
-		if mType == "gauge" {
-			query = `INSERT INTO gauges (name, value) VALUES ($1, $2)`
-		} else if mType == "counter" {
-			query = `INSERT INTO counters (name, value) VALUES ($1, $2)`
-		}
-		res, err := db.conn.Exec(query, mName, mVal)
-		if err != nil {
-			fmt.Println("WRITEMETRIC: " + fmt.Sprint(err))
-			return nil, err
-		}
-		fmt.Println(res)
-		return nil, nil
+		var version int64
+		err := db.pool.QueryRow(ctx, "SELECT COALESCE(max(version), 0) FROM metrics").Scan(&version)
+		return version, err
 	}
 }
 
-func (db *DBConnection) WriteMetrics(metrics *[]memstorage.Metrics) RetryFunc {
+// DeleteSamples удаляет из samples значения старше before.
+func (db *DBConnection) DeleteSamples(ctx context.Context, before time.Time) RetryFunc {
 	return func() (interface{}, error) {
-		tx, err := db.conn.Begin()
+		res, err := db.pool.Exec(ctx, "DELETE FROM samples WHERE ts < $1", before)
 		if err != nil {
 			return nil, err
 		}
-		for _, v := range *metrics {
-			if v.Value == nil {
-				_, err := tx.Exec(
-					"INSERT INTO counters (name, value)"+
-						" VALUES($1,$2)", v.ID, *v.Delta)
-				if err != nil {
-					tx.Rollback()
-					return nil, err
-				}
-			} else {
-				_, err := tx.Exec(
-					"INSERT INTO gauges (name, value)"+
-						" VALUES($1,$2)", v.ID, *v.Value)
-				if err != nil {
-					tx.Rollback()
-					return nil, err
-				}
-			}
-		}
-		return nil, tx.Commit()
+		return res.RowsAffected(), nil
 	}
 }
 
-func (db *DBConnection) ReadMemStorage() RetryFunc {
+func (db *DBConnection) ReadMemStorage(ctx context.Context) RetryFunc {
 	return func() (interface{}, error) {
 		storage := memstorage.NewMemStorage()
-		query := `
-		SELECT name, value 
-		FROM gauges
-		WHERE id IN (
-			SELECT MAX(id)
-			FROM gauges
-			WHERE name = gauges.name
-			GROUP BY name
-		)
-	`
-		rows, err := db.conn.Query(query)
+		rows, err := db.pool.Query(ctx, "SELECT type, name, labels, value, delta, bounds, counts, sum, count FROM metrics")
 		if err != nil {
 			return nil, err
 		}
+		defer rows.Close()
 		for rows.Next() {
-			var mName string
-			var mVal float64
-			err := rows.Scan(&mName, &mVal)
+			var mType, mName, mLabels string
+			var value, sum *float64
+			var delta, count *int64
+			var bounds []float64
+			var counts []int64
+			err := rows.Scan(&mType, &mName, &mLabels, &value, &delta, &bounds, &counts, &sum, &count)
 			if err != nil {
 				return nil, err
 			}
-			storage.PutGauge(mName, mVal)
-		}
-		rows.Close()
-
-		query = `
-		SELECT name, value 
-		FROM counters 
-		WHERE id IN (
-			SELECT MAX(id)
-			FROM counters
-			WHERE name = counters.name
-			GROUP BY name
-		)
-	`
-		rows, err = db.conn.Query(query)
-		if err != nil {
-			return nil, err
-		}
-		for rows.Next() {
-			var mName string
-			var mVal int64
-			err := rows.Scan(&mName, &mVal)
+			labels, err := memstorage.ParseLabels(mLabels)
 			if err != nil {
 				return nil, err
 			}
-			storage.PutCounter(mName, mVal)
+			key := memstorage.SeriesKey(mName, labels)
+			switch {
+			case mType == "gauge" && value != nil:
+				storage.PutGauge(key, *value)
+			case mType == "counter" && delta != nil:
+				storage.SetCounter(key, *delta)
+			case mType == "histogram" && sum != nil && count != nil:
+				hist := &memstorage.Histogram{Bounds: bounds, Counts: make([]uint64, len(counts)), Sum: *sum, Count: uint64(*count)}
+				for i, c := range counts {
+					hist.Counts[i] = uint64(c)
+				}
+				storage.SetHistogram(key, hist)
+			}
 		}
-		rows.Close()
-
-		return storage, nil
+		return storage, rows.Err()
 	}
 }
@@ -0,0 +1,120 @@
+package psqlinteraction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "Test1", err: &pgconn.PgError{Code: pgerrcode.ConnectionFailure}, want: true},
+		{name: "Test2", err: &pgconn.PgError{Code: pgerrcode.DeadlockDetected}, want: true},
+		{name: "Test3", err: &pgconn.PgError{Code: pgerrcode.UniqueViolation}},
+		{name: "Test4", err: fmt.Errorf("write: %w", context.Canceled)},
+		{name: "Test5", err: errors.New("some error")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Retryable(tt.err); got != tt.want {
+				t.Errorf("Retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testConnection(t *testing.T) *DBConnection {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	ctx := context.Background()
+	obj, err := Retry(ctx, NewDBConnection(ctx, dsn, PoolConfig{MaxConns: 4, StatementCacheCapacity: 16}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := obj.(*DBConnection)
+	t.Cleanup(func() { db.Close() })
+	// повторное применение миграций ничего не меняет
+	for i := 0; i < 2; i++ {
+		if _, err := Retry(ctx, db.InitTables(ctx)); err != nil {
+			t.Fatalf("InitTables() error = %v", err)
+		}
+	}
+	return db
+}
+
+func TestDBConnection_WriteMetrics(t *testing.T) {
+	db := testConnection(t)
+	ctx := context.Background()
+	// уникальный префикс, чтобы прогоны на общей базе не мешали друг другу
+	prefix := fmt.Sprintf("pg%d", time.Now().UnixNano())
+	labels := map[string]string{"host": "a"}
+
+	// одновременные записи через пул: остаётся значение с наибольшей версией
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 1; i <= 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			delta, value := int64(i), float64(i)
+			hist := memstorage.NewHistogram([]float64{1})
+			hist.Observe(value)
+			metrics := []memstorage.Metrics{
+				{ID: prefix + "Counter", MType: "counter", Delta: &delta, Labels: labels},
+				{ID: prefix + "Gauge", MType: "gauge", Value: &value},
+				{ID: prefix + "Hist", MType: "histogram", Histogram: hist},
+			}
+			if _, err := Retry(ctx, db.WriteMetrics(ctx, &metrics, int64(1000+i))); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("WriteMetrics() error = %v", err)
+	}
+
+	// запоздавшая запись с меньшей версией не затирает значение
+	stale := int64(1)
+	if _, err := db.WriteMetrics(ctx, &[]memstorage.Metrics{{ID: prefix + "Counter", MType: "counter", Delta: &stale, Labels: labels}}, 1000)(); err != nil {
+		t.Fatalf("WriteMetrics(stale) error = %v", err)
+	}
+
+	obj, err := db.ReadMemStorage(ctx)()
+	if err != nil {
+		t.Fatalf("ReadMemStorage() error = %v", err)
+	}
+	mem := obj.(*memstorage.MemStorage)
+	if got := mem.GetCounters()[memstorage.SeriesKey(prefix+"Counter", labels)]; got != 16 {
+		t.Errorf("counter = %d, want 16", got)
+	}
+	if got := mem.GetGauges()[prefix+"Gauge"]; got != 16 {
+		t.Errorf("gauge = %v, want 16", got)
+	}
+	if hist := mem.GetHistograms()[prefix+"Hist"]; hist == nil || hist.Count != 1 || hist.Sum != 16 || len(hist.Counts) != 2 {
+		t.Errorf("histogram = %+v", hist)
+	}
+
+	obj, err = db.MaxVersion(ctx)()
+	if err != nil {
+		t.Fatalf("MaxVersion() error = %v", err)
+	}
+	if version := obj.(int64); version < 1016 {
+		t.Errorf("MaxVersion() = %d, want at least 1016", version)
+	}
+}
@@ -0,0 +1,229 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+// Source - откуда берутся метрики для вычисления, его реализует storage.Storage.
+type Source interface {
+	List(ctx context.Context) ([]memstorage.Metrics, error)
+	History(ctx context.Context, mType, mName string, labels map[string]string, from, to time.Time, step time.Duration) ([]memstorage.Sample, error)
+}
+
+// Series - один ряд результата. У агрегатов Name и Type пустые, а Labels
+// содержат только метки группировки.
+type Series struct {
+	Name   string            `json:"name,omitempty"`
+	Type   string            `json:"type,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// Eval вычисляет выражение. now - конец окна для функций по истории, селекторы
+// без функций всегда возвращают текущие значения. Гистограммы в выражениях не участвуют.
+func Eval(ctx context.Context, src Source, e Expr, now time.Time) ([]Series, error) {
+	var (
+		res []Series
+		err error
+	)
+	switch e := e.(type) {
+	case *Selector:
+		res, err = evalSelector(ctx, src, e)
+	case *Call:
+		res, err = evalCall(ctx, src, e, now)
+	case *Aggregate:
+		res, err = evalAggregate(ctx, src, e, now)
+	default:
+		return nil, fmt.Errorf("unknown expression %T", e)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Name != res[j].Name {
+			return res[i].Name < res[j].Name
+		}
+		return memstorage.FormatLabels(res[i].Labels) < memstorage.FormatLabels(res[j].Labels)
+	})
+	return res, nil
+}
+
+// matchName сравнивает имя с шаблоном, в котором * - любая последовательность символов.
+func matchName(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return strings.HasSuffix(name, parts[len(parts)-1])
+}
+
+func (s *Selector) matches(metric *memstorage.Metrics) bool {
+	if metric.MType != "gauge" && metric.MType != "counter" {
+		return false
+	}
+	if !matchName(s.Pattern, metric.ID) {
+		return false
+	}
+	for k, v := range s.Matchers {
+		if metric.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func selectMetrics(ctx context.Context, src Source, sel *Selector) ([]memstorage.Metrics, error) {
+	metrics, err := src.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := metrics[:0]
+	for _, m := range metrics {
+		if sel.matches(&m) {
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+func evalSelector(ctx context.Context, src Source, sel *Selector) ([]Series, error) {
+	metrics, err := selectMetrics(ctx, src, sel)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Series, 0, len(metrics))
+	for _, m := range metrics {
+		value := 0.0
+		if m.MType == "counter" {
+			value = float64(*m.Delta)
+		} else {
+			value = *m.Value
+		}
+		res = append(res, Series{Name: m.ID, Type: m.MType, Labels: m.Labels, Value: value})
+	}
+	return res, nil
+}
+
+// increase считает прирост по сэмплам. Уменьшение значения считается сбросом
+// счётчика: прирост после сброса отсчитывается от нуля.
+func increase(samples []memstorage.Sample) float64 {
+	res := 0.0
+	for i := 1; i < len(samples); i++ {
+		if d := samples[i].Value - samples[i-1].Value; d >= 0 {
+			res += d
+		} else {
+			res += samples[i].Value
+		}
+	}
+	return res
+}
+
+func evalCall(ctx context.Context, src Source, call *Call, now time.Time) ([]Series, error) {
+	metrics, err := selectMetrics(ctx, src, call.Arg)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Series, 0, len(metrics))
+	for _, m := range metrics {
+		samples, err := src.History(ctx, m.MType, m.ID, m.Labels, now.Add(-call.Arg.Range), now, 0)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// по одному сэмплу скорость не посчитать
+		if len(samples) < 2 {
+			continue
+		}
+		value := increase(samples)
+		if call.Func == "rate" {
+			value /= call.Arg.Range.Seconds()
+		}
+		res = append(res, Series{Name: m.ID, Type: m.MType, Labels: m.Labels, Value: value})
+	}
+	return res, nil
+}
+
+func evalAggregate(ctx context.Context, src Source, agg *Aggregate, now time.Time) ([]Series, error) {
+	args, err := Eval(ctx, src, agg.Arg, now)
+	if err != nil {
+		return nil, err
+	}
+	type group struct {
+		labels map[string]string
+		values []float64
+	}
+	groups := make(map[string]*group)
+	for _, s := range args {
+		var labels map[string]string
+		if len(agg.By) > 0 {
+			labels = make(map[string]string, len(agg.By))
+			for _, k := range agg.By {
+				if v, ok := s.Labels[k]; ok {
+					labels[k] = v
+				}
+			}
+		}
+		key := memstorage.FormatLabels(labels)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: labels}
+			groups[key] = g
+		}
+		g.values = append(g.values, s.Value)
+	}
+
+	res := make([]Series, 0, len(groups))
+	for _, g := range groups {
+		res = append(res, Series{Labels: g.labels, Value: aggregate(agg.Op, g.values)})
+	}
+	return res, nil
+}
+
+func aggregate(op string, values []float64) float64 {
+	switch op {
+	case "count":
+		return float64(len(values))
+	case "min":
+		res := math.Inf(1)
+		for _, v := range values {
+			res = math.Min(res, v)
+		}
+		return res
+	case "max":
+		res := math.Inf(-1)
+		for _, v := range values {
+			res = math.Max(res, v)
+		}
+		return res
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	if op == "avg" {
+		return sum / float64(len(values))
+	}
+	return sum
+}
@@ -0,0 +1,108 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/storage"
+)
+
+type stubSource struct {
+	metrics []memstorage.Metrics
+	history map[string][]float64
+	err     error
+}
+
+func (s *stubSource) List(ctx context.Context) ([]memstorage.Metrics, error) {
+	return append([]memstorage.Metrics(nil), s.metrics...), nil
+}
+
+func (s *stubSource) History(ctx context.Context, mType, mName string, labels map[string]string, from, to time.Time, step time.Duration) ([]memstorage.Sample, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	values, ok := s.history[memstorage.SeriesKey(mName, labels)]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	res := make([]memstorage.Sample, len(values))
+	for i, v := range values {
+		res[i] = memstorage.Sample{Time: from.Add(time.Duration(i) * time.Second), Value: v}
+	}
+	return res, nil
+}
+
+func gauge(name string, value float64, labels map[string]string) memstorage.Metrics {
+	return memstorage.Metrics{ID: name, MType: "gauge", Value: &value, Labels: labels}
+}
+
+func counter(name string, delta int64, labels map[string]string) memstorage.Metrics {
+	return memstorage.Metrics{ID: name, MType: "counter", Delta: &delta, Labels: labels}
+}
+
+func TestEval(t *testing.T) {
+	hostA := map[string]string{"host": "a"}
+	hostB := map[string]string{"host": "b"}
+	src := &stubSource{
+		metrics: []memstorage.Metrics{
+			gauge("CPUutilization1", 10, nil),
+			gauge("CPUutilization2", 30, nil),
+			gauge("HeapAlloc", 100, hostA),
+			gauge("HeapAlloc", 300, hostB),
+			gauge("HeapAlloc", 200, hostB),
+			counter("PollCount", 70, hostA),
+			counter("PollCount", 5, hostB),
+			{ID: "latency", MType: "histogram", Histogram: memstorage.NewHistogram(memstorage.DefaultBuckets)},
+		},
+		history: map[string][]float64{
+			memstorage.SeriesKey("PollCount", hostA): {10, 40, 70},
+			// сброс счётчика: 20 -> 5
+			memstorage.SeriesKey("PollCount", hostB): {0, 20, 5},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []Series
+	}{
+		{name: "Test1", query: "sum(CPUutilization*)", want: []Series{{Value: 40}}},
+		{name: "Test2", query: "max by host(HeapAlloc)", want: []Series{{Labels: hostA, Value: 100}, {Labels: hostB, Value: 300}}},
+		{name: "Test3", query: "rate(PollCount[1m])", want: []Series{
+			{Name: "PollCount", Type: "counter", Labels: hostA, Value: 1},
+			{Name: "PollCount", Type: "counter", Labels: hostB, Value: 25.0 / 60},
+		}},
+		{name: "Test4", query: `increase(PollCount{host="b"}[1m])`, want: []Series{{Name: "PollCount", Type: "counter", Labels: hostB, Value: 25}}},
+		{name: "Test5", query: `HeapAlloc{host="a"}`, want: []Series{{Name: "HeapAlloc", Type: "gauge", Labels: hostA, Value: 100}}},
+		{name: "Test6", query: "count(*)", want: []Series{{Value: 7}}},
+		{name: "Test7", query: "avg(min by host(HeapAlloc))", want: []Series{{Value: 150}}},
+		{name: "Test8", query: "sum(Missing)", want: []Series{}},
+		{name: "Test9", query: "latency", want: []Series{}},
+		{name: "Test10", query: "PollCount{host=\"a\"}", want: []Series{{Name: "PollCount", Type: "counter", Labels: hostA, Value: 70}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Parse(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Eval(context.Background(), src, e, time.Now())
+			if err != nil {
+				t.Fatalf("Eval() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	src.err = storage.ErrHistoryDisabled
+	e, _ := Parse("rate(PollCount[1m])")
+	if _, err := Eval(context.Background(), src, e, time.Now()); !errors.Is(err, storage.ErrHistoryDisabled) {
+		t.Errorf("Eval() error = %v, want ErrHistoryDisabled", err)
+	}
+}
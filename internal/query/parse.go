@@ -0,0 +1,265 @@
+// Package query разбирает и вычисляет выражения над метриками хранилища:
+//
+//	HeapAlloc                     значения метрики
+//	CPUutilization*{host="a"}     метрики по шаблону имени и меткам
+//	sum(CPUutilization*)          агрегация: sum, min, max, avg, count
+//	max by host(HeapAlloc)        агрегация с группировкой, by (host, dc) для нескольких меток
+//	rate(PollCount[1m])           скорость роста по истории: rate, increase
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Expr - узел разобранного выражения: *Selector, *Aggregate или *Call.
+type Expr interface {
+	expr()
+}
+
+// Selector выбирает метрики, имя которых подходит под шаблон Pattern
+// (* - любая последовательность символов) и у которых есть все метки Matchers.
+// Range задаётся только внутри функций по истории.
+type Selector struct {
+	Pattern  string
+	Matchers map[string]string
+	Range    time.Duration
+}
+
+// Aggregate сворачивает результат Arg функцией Op, отдельно для каждой
+// комбинации значений меток By.
+type Aggregate struct {
+	Op  string
+	By  []string
+	Arg Expr
+}
+
+// Call вычисляет функцию Func по истории метрик Arg за Arg.Range.
+type Call struct {
+	Func string
+	Arg  *Selector
+}
+
+func (*Selector) expr()  {}
+func (*Aggregate) expr() {}
+func (*Call) expr()      {}
+
+var aggregations = map[string]bool{"sum": true, "min": true, "max": true, "avg": true, "count": true}
+
+var functions = map[string]bool{"rate": true, "increase": true}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func isIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '.' || c == ':' || c == '-' || c == '*'
+}
+
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case strings.IndexByte("(){}[],=", c) >= 0:
+			tokens = append(tokens, token{kind: tokPunct, text: string(c), pos: i})
+			i++
+		case c == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{kind: tokString, text: s[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case isIdentChar(c):
+			start := i
+			for i < len(s) && isIdentChar(s[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: s[start:i], pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(s)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(text string) error {
+	if t := p.next(); t.kind != tokPunct || t.text != text {
+		return fmt.Errorf("expected %q at %d", text, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) isPunct(text string) bool {
+	t := p.peek()
+	return t.kind == tokPunct && t.text == text
+}
+
+// Parse разбирает выражение s.
+func Parse(s string) (Expr, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	if sel, ok := e.(*Selector); ok && sel.Range != 0 {
+		return nil, errors.New("range selector must be used in rate or increase")
+	}
+	return e, nil
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, fmt.Errorf("expected metric name or function at %d", t.pos)
+	}
+	switch {
+	case aggregations[t.text] && (p.isPunct("(") || p.peek().text == "by"):
+		return p.parseAggregate(t.text)
+	case functions[t.text] && p.isPunct("("):
+		return p.parseCall(t.text)
+	}
+	return p.parseSelector(t)
+}
+
+func (p *parser) parseAggregate(op string) (Expr, error) {
+	agg := &Aggregate{Op: op}
+	if p.peek().kind == tokIdent && p.peek().text == "by" {
+		p.next()
+		if p.isPunct("(") {
+			p.next()
+			for {
+				t := p.next()
+				if t.kind != tokIdent {
+					return nil, fmt.Errorf("expected label name at %d", t.pos)
+				}
+				agg.By = append(agg.By, t.text)
+				if p.isPunct(")") {
+					p.next()
+					break
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+		} else {
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, fmt.Errorf("expected label name at %d", t.pos)
+			}
+			agg.By = []string{t.text}
+		}
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	arg, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if sel, ok := arg.(*Selector); ok && sel.Range != 0 {
+		return nil, errors.New("range selector must be used in rate or increase")
+	}
+	agg.Arg = arg
+	return agg, p.expect(")")
+}
+
+func (p *parser) parseCall(fn string) (Expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, fmt.Errorf("expected metric name at %d", t.pos)
+	}
+	sel, err := p.parseSelector(t)
+	if err != nil {
+		return nil, err
+	}
+	if sel.Range == 0 {
+		return nil, fmt.Errorf("%s needs a range, e.g. %s(%s[1m])", fn, fn, sel.Pattern)
+	}
+	return &Call{Func: fn, Arg: sel}, p.expect(")")
+}
+
+func (p *parser) parseSelector(name token) (*Selector, error) {
+	sel := &Selector{Pattern: name.text}
+	if p.isPunct("{") {
+		p.next()
+		sel.Matchers = make(map[string]string)
+		for !p.isPunct("}") {
+			key := p.next()
+			if key.kind != tokIdent {
+				return nil, fmt.Errorf("expected label name at %d", key.pos)
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			value := p.next()
+			if value.kind != tokString {
+				return nil, fmt.Errorf("expected quoted label value at %d", value.pos)
+			}
+			sel.Matchers[key.text] = value.text
+			if !p.isPunct("}") {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+		p.next()
+	}
+	if p.isPunct("[") {
+		p.next()
+		t := p.next()
+		d, err := time.ParseDuration(t.text)
+		if t.kind != tokIdent || err != nil || d <= 0 {
+			return nil, fmt.Errorf("bad range %q at %d", t.text, t.pos)
+		}
+		sel.Range = d
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
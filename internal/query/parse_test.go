@@ -0,0 +1,72 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Expr
+		wantErr bool
+	}{
+		{name: "Test1", input: "HeapAlloc", want: &Selector{Pattern: "HeapAlloc"}},
+		{name: "Test2", input: `CPUutilization*{host="a", dc="eu"}`,
+			want: &Selector{Pattern: "CPUutilization*", Matchers: map[string]string{"host": "a", "dc": "eu"}}},
+		{name: "Test3", input: "sum(CPUutilization*)",
+			want: &Aggregate{Op: "sum", Arg: &Selector{Pattern: "CPUutilization*"}}},
+		{name: "Test4", input: "max by host(HeapAlloc)",
+			want: &Aggregate{Op: "max", By: []string{"host"}, Arg: &Selector{Pattern: "HeapAlloc"}}},
+		{name: "Test5", input: "avg by (host, dc) (rate(PollCount[1m]))",
+			want: &Aggregate{Op: "avg", By: []string{"host", "dc"}, Arg: &Call{Func: "rate", Arg: &Selector{Pattern: "PollCount", Range: time.Minute}}}},
+		{name: "Test6", input: `increase(requests{route="/pay"}[30s])`,
+			want: &Call{Func: "increase", Arg: &Selector{Pattern: "requests", Matchers: map[string]string{"route": "/pay"}, Range: 30 * time.Second}}},
+		// имя метрики может совпадать с именем функции
+		{name: "Test7", input: "count", want: &Selector{Pattern: "count"}},
+		{name: "Test8", input: "rate(PollCount)", wantErr: true},
+		{name: "Test9", input: "PollCount[1m]", wantErr: true},
+		{name: "Test10", input: "sum(HeapAlloc", wantErr: true},
+		{name: "Test11", input: `HeapAlloc{host=a}`, wantErr: true},
+		{name: "Test12", input: "rate(PollCount[1x])", wantErr: true},
+		{name: "Test13", input: "HeapAlloc Alloc", wantErr: true},
+		{name: "Test14", input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_matchName(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		want    bool
+	}{
+		{name: "Test1", pattern: "CPUutilization1", want: true},
+		{name: "Test2", pattern: "CPU*", want: true},
+		{name: "Test3", pattern: "*1", want: true},
+		{name: "Test4", pattern: "C*util*n1", want: true},
+		{name: "Test5", pattern: "*", want: true},
+		{name: "Test6", pattern: "CPU", want: false},
+		{name: "Test7", pattern: "CPU*2", want: false},
+		{name: "Test8", pattern: "CPUutilization1*1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchName(tt.pattern, "CPUutilization1"); got != tt.want {
+				t.Errorf("matchName(%q) = %v, want %v", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Policy описывает, сколько раз и с какими паузами повторять операцию.
+type Policy struct {
+	// MaxAttempts - общее число попыток, включая первую
+	MaxAttempts int
+	// BaseDelay - пауза после первой неудачной попытки
+	BaseDelay time.Duration
+	// MaxDelay ограничивает паузу сверху, 0 - без ограничения
+	MaxDelay time.Duration
+	// Multiplier - во сколько раз растёт пауза с каждой попыткой, 0 трактуется как 1
+	Multiplier float64
+	// Jitter - доля паузы от 0 до 1, на которую она случайно уменьшается,
+	// чтобы клиенты не повторяли запросы одновременно
+	Jitter float64
+	// Retryable решает, стоит ли повторять после ошибки. nil - повторять всегда.
+	Retryable func(error) bool
+}
+
+// Delay возвращает паузу после attempt-й неудачной попытки (нумерация с 1).
+func (p Policy) Delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 1
+	}
+	delay := float64(p.BaseDelay) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay -= delay * p.Jitter * rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+// Do выполняет f, пока она не завершится успешно, не вернёт неповторяемую
+// ошибку, не кончатся попытки или не будет отменён ctx.
+func (p Policy) Do(ctx context.Context, f func() error) error {
+	attempts := p.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for i := 1; ; i++ {
+		if err = f(); err == nil {
+			return nil
+		}
+		if p.Retryable != nil && !p.Retryable(err) {
+			return err
+		}
+		if i >= attempts {
+			return fmt.Errorf("all %d attempts failed: %w", attempts, err)
+		}
+		timer := time.NewTimer(p.Delay(i))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w, last error: %v", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errTemporary = errors.New("temporary")
+
+func TestPolicy_Delay(t *testing.T) {
+	p := Policy{BaseDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 2}
+	tests := []struct {
+		name    string
+		attempt int
+		want    time.Duration
+	}{
+		{name: "Test1", attempt: 1, want: time.Second},
+		{name: "Test2", attempt: 2, want: 2 * time.Second},
+		{name: "Test3", attempt: 3, want: 4 * time.Second},
+		{name: "Test4", attempt: 10, want: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Delay(tt.attempt); got != tt.want {
+				t.Errorf("Delay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.Delay(1); got < 500*time.Millisecond || got > time.Second {
+			t.Fatalf("Delay() with jitter = %v, want between 0.5s and 1s", got)
+		}
+	}
+}
+
+func TestPolicy_Do(t *testing.T) {
+	permanent := errors.New("permanent")
+	p := Policy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		Retryable:   func(err error) bool { return errors.Is(err, errTemporary) },
+	}
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{name: "Test1", errs: []error{nil}, wantCalls: 1},
+		{name: "Test2", errs: []error{errTemporary, errTemporary, nil}, wantCalls: 3},
+		{name: "Test3", errs: []error{errTemporary, errTemporary, errTemporary}, wantCalls: 3, wantErr: errTemporary},
+		{name: "Test4", errs: []error{permanent}, wantCalls: 1, wantErr: permanent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := p.Do(context.Background(), func() error {
+				calls++
+				return tt.errs[calls-1]
+			})
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("Do() error = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("Do() made %d calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestPolicy_DoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Policy{MaxAttempts: 5, BaseDelay: time.Hour}
+	calls := 0
+	err := p.Do(ctx, func() error {
+		calls++
+		cancel()
+		return errTemporary
+	})
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Errorf("Do() = %v after %d calls, want context.Canceled after 1", err, calls)
+	}
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kishenkoilya/metricsalerts/internal/filerw"
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+)
+
+// FileStorage хранит метрики в памяти и сохраняет их в файл: либо каждое
+// изменение сразу (syncWrite), либо целиком при вызове Snapshot.
+type FileStorage struct {
+	*MemoryStorage
+	path       string
+	syncWriter *filerw.Producer
+	// writeMu упорядочивает изменения и записи в файл, чтобы последняя
+	// строка для метрики всегда содержала её актуальное значение
+	writeMu sync.Mutex
+	// dirty - синхронная запись не удалась и файл отстаёт от памяти,
+	// persistLoop перезапишет его целиком
+	dirty     bool
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewFileStorage(mem *memstorage.MemStorage, path string, syncWrite bool) (*FileStorage, error) {
+	s := &FileStorage{MemoryStorage: NewMemoryStorage(mem), path: path, done: make(chan struct{})}
+	if syncWrite {
+		producer, err := filerw.NewProducer(path, false)
+		if err != nil {
+			return nil, err
+		}
+		s.syncWriter = producer
+		go s.persistLoop()
+	}
+	return s, nil
+}
+
+// write дописывает итоговые значения в файл, вызывается под writeMu.
+// Об ошибках записи см. persistRetryInterval.
+func (s *FileStorage) write(metrics *[]memstorage.Metrics) {
+	if s.dirty {
+		return
+	}
+	if err := s.syncWriter.WriteMetrics(metrics); err != nil {
+		fmt.Println("Failed to write metrics to file, will retry: " + err.Error())
+		s.dirty = true
+	}
+}
+
+// persistLoop перезаписывает файл, если синхронная запись не удалась.
+func (s *FileStorage) persistLoop() {
+	ticker := time.NewTicker(persistRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.writeMu.Lock()
+			if s.dirty {
+				if err := s.rewrite(); err != nil {
+					fmt.Println("Failed to rewrite metrics file: " + err.Error())
+				}
+			}
+			s.writeMu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// rewrite записывает в файл текущее состояние памяти и заново открывает
+// файл для дозаписи: после ошибки старый писатель непригоден.
+func (s *FileStorage) rewrite() error {
+	if err := s.snapshot(); err != nil {
+		return err
+	}
+	producer, err := filerw.NewProducer(s.path, false)
+	if err != nil {
+		return err
+	}
+	s.syncWriter.Close()
+	s.syncWriter = producer
+	s.dirty = false
+	return nil
+}
+
+func (s *FileStorage) Save(ctx context.Context, metric *memstorage.Metrics) (*memstorage.Metrics, error) {
+	if s.syncWriter != nil {
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+	}
+	res, err := s.MemoryStorage.Save(ctx, metric)
+	if err != nil {
+		return nil, err
+	}
+	if s.syncWriter != nil {
+		s.write(&[]memstorage.Metrics{*res})
+	}
+	return res, nil
+}
+
+func (s *FileStorage) SaveBatch(ctx context.Context, metrics []memstorage.Metrics) ([]memstorage.Metrics, error) {
+	if s.syncWriter != nil {
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+	}
+	res, err := s.MemoryStorage.SaveBatch(ctx, metrics)
+	if err != nil {
+		return nil, err
+	}
+	if s.syncWriter != nil {
+		s.write(&res)
+	}
+	return res, nil
+}
+
+func (s *FileStorage) Snapshot(ctx context.Context) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.dirty {
+		return s.rewrite()
+	}
+	return s.snapshot()
+}
+
+func (s *FileStorage) snapshot() error {
+	producer, err := filerw.NewProducer(s.path, true)
+	if err != nil {
+		return err
+	}
+	return producer.WriteMemStorage(s.mem)
+}
+
+func (s *FileStorage) Restore(ctx context.Context) error {
+	consumer, err := filerw.NewConsumer(s.path)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+	restored, err := consumer.ReadMemStorage()
+	if err != nil {
+		return err
+	}
+	s.mem.Load(restored)
+	return nil
+}
+
+func (s *FileStorage) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+		if s.syncWriter == nil {
+			return
+		}
+		// последняя попытка догнать память перед остановкой
+		if s.dirty {
+			err = s.rewrite()
+		}
+		if cerr := s.syncWriter.Close(); err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/psqlinteraction"
+)
+
+// DBStorage хранит метрики в памяти и сохраняет их в Postgres: либо каждое
+// изменение сразу (syncWrite), либо целиком при вызове Snapshot.
+// При ненулевом samplesRetention записанные значения дополнительно
+// сохраняются в таблицу samples и удаляются из неё по истечении срока.
+type DBStorage struct {
+	*MemoryStorage
+	db        *psqlinteraction.DBConnection
+	syncWrite bool
+	// mu связывает изменение памяти с версией записи. Сама запись в базу
+	// идёт без блокировки, а порядок соблюдает база: строка с меньшей
+	// версией не затирает строку с большей.
+	mu      sync.Mutex
+	version int64
+	// dirty - синхронная запись не удалась и база отстаёт от памяти,
+	// persistLoop перезапишет её целиком
+	dirty     bool
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewDBStorage(mem *memstorage.MemStorage, dsn string, syncWrite bool, samplesRetention time.Duration, pool psqlinteraction.PoolConfig) (*DBStorage, error) {
+	ctx := context.Background()
+	obj, err := psqlinteraction.Retry(ctx, psqlinteraction.NewDBConnection(ctx, dsn, pool))
+	if err != nil {
+		return nil, err
+	}
+	db := obj.(*psqlinteraction.DBConnection)
+	_, err = psqlinteraction.Retry(ctx, db.InitTables(ctx))
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	obj, err = psqlinteraction.Retry(ctx, db.MaxVersion(ctx))
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &DBStorage{MemoryStorage: NewMemoryStorage(mem), db: db, syncWrite: syncWrite, version: obj.(int64), done: make(chan struct{})}
+	if samplesRetention > 0 {
+		db.RecordSamples(true)
+		go s.pruneSamples(samplesRetention)
+	}
+	if syncWrite {
+		go s.persistLoop()
+	}
+	return s, nil
+}
+
+// nextVersion возвращает версию для записи текущего состояния памяти,
+// вызывается под mu. Версии берутся из времени, чтобы расти и после
+// перезапуска сервера.
+func (s *DBStorage) nextVersion() int64 {
+	version := time.Now().UnixNano()
+	if version <= s.version {
+		version = s.version + 1
+	}
+	s.version = version
+	return version
+}
+
+func (s *DBStorage) setDirty() {
+	s.mu.Lock()
+	s.dirty = true
+	s.mu.Unlock()
+}
+
+// write записывает итоговые значения в базу. Об ошибках записи см.
+// persistRetryInterval.
+func (s *DBStorage) write(ctx context.Context, metrics *[]memstorage.Metrics, version int64) {
+	if _, err := s.db.WriteMetrics(ctx, metrics, version)(); err != nil {
+		fmt.Println("Failed to write metrics to database, will retry: " + err.Error())
+		s.setDirty()
+	}
+}
+
+// rewrite записывает в базу всё состояние памяти, с повторами по
+// RetryPolicy, если retry. Если не удалось, база остаётся помеченной устаревшей.
+func (s *DBStorage) rewrite(ctx context.Context, retry bool) error {
+	s.mu.Lock()
+	metrics, _ := s.MemoryStorage.List(ctx)
+	version := s.nextVersion()
+	s.dirty = false
+	s.mu.Unlock()
+	write := s.db.WriteMetrics(ctx, &metrics, version)
+	var err error
+	if retry {
+		_, err = psqlinteraction.Retry(ctx, write)
+	} else {
+		_, err = write()
+	}
+	if err != nil {
+		s.setDirty()
+		return err
+	}
+	return nil
+}
+
+// persistLoop перезаписывает базу, если синхронная запись не удалась.
+func (s *DBStorage) persistLoop() {
+	ticker := time.NewTicker(persistRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			dirty := s.dirty
+			s.mu.Unlock()
+			if !dirty {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), persistRetryInterval)
+			err := s.rewrite(ctx, false)
+			cancel()
+			if err != nil {
+				fmt.Println("Failed to rewrite metrics in database: " + err.Error())
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// pruneSamples раз в минуту удаляет значения старше retention.
+func (s *DBStorage) pruneSamples(retention time.Duration) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			_, err := psqlinteraction.Retry(ctx, s.db.DeleteSamples(ctx, time.Now().Add(-retention)))
+			cancel()
+			if err != nil {
+				fmt.Println("Failed to prune samples: " + err.Error())
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *DBStorage) Save(ctx context.Context, metric *memstorage.Metrics) (*memstorage.Metrics, error) {
+	if !s.syncWrite {
+		return s.MemoryStorage.Save(ctx, metric)
+	}
+	s.mu.Lock()
+	res, err := s.MemoryStorage.Save(ctx, metric)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	version, dirty := s.nextVersion(), s.dirty
+	s.mu.Unlock()
+	// пока база устарела, отдельные записи не нужны: её перезапишет persistLoop
+	if !dirty {
+		s.write(ctx, &[]memstorage.Metrics{*res}, version)
+	}
+	return res, nil
+}
+
+func (s *DBStorage) SaveBatch(ctx context.Context, metrics []memstorage.Metrics) ([]memstorage.Metrics, error) {
+	if !s.syncWrite {
+		return s.MemoryStorage.SaveBatch(ctx, metrics)
+	}
+	s.mu.Lock()
+	res, err := s.MemoryStorage.SaveBatch(ctx, metrics)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	version, dirty := s.nextVersion(), s.dirty
+	s.mu.Unlock()
+	if !dirty {
+		s.write(ctx, &res, version)
+	}
+	return res, nil
+}
+
+func (s *DBStorage) Snapshot(ctx context.Context) error {
+	return s.rewrite(ctx, true)
+}
+
+func (s *DBStorage) Restore(ctx context.Context) error {
+	obj, err := psqlinteraction.Retry(ctx, s.db.ReadMemStorage(ctx))
+	if err != nil {
+		return err
+	}
+	s.mem.Load(obj.(*memstorage.MemStorage))
+	return nil
+}
+
+func (s *DBStorage) Ping(ctx context.Context) error {
+	_, err := psqlinteraction.Retry(ctx, s.db.Ping(ctx))
+	return err
+}
+
+func (s *DBStorage) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		err = s.db.Close()
+	})
+	return err
+}
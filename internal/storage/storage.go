@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+)
+
+var (
+	ErrNotFound        = errors.New("metric not found")
+	ErrInvalidMetric   = errors.New("invalid metric")
+	ErrHistoryDisabled = errors.New("metric history is disabled")
+)
+
+// persistRetryInterval - период повтора синхронной записи, которая не удалась.
+//
+// Синхронная запись в файл или базу идёт после изменения памяти, поэтому её
+// ошибка не возвращается клиенту: повтор запроса удвоил бы счётчики. Вместо
+// этого хранилище помечается устаревшим (dirty) и отдельные записи
+// прекращаются, а persistLoop раз в persistRetryInterval перезаписывает его
+// целиком. Каждая запись делается одной попыткой, повторами служит сам цикл.
+const persistRetryInterval = 5 * time.Second
+
+// Storage - общее хранилище метрик сервера. Все реализации держат актуальные
+// значения в memstorage.MemStorage и отличаются только способом сохранения.
+type Storage interface {
+	// Save сохраняет метрику и возвращает её итоговое значение
+	Save(ctx context.Context, metric *memstorage.Metrics) (*memstorage.Metrics, error)
+	// SaveBatch сохраняет пачку метрик и возвращает итоговые значения затронутых метрик
+	SaveBatch(ctx context.Context, metrics []memstorage.Metrics) ([]memstorage.Metrics, error)
+	Get(ctx context.Context, mType, mName string, labels map[string]string) (*memstorage.Metrics, error)
+	List(ctx context.Context) ([]memstorage.Metrics, error)
+	// History возвращает историю значений метрики за [from, to] с шагом step
+	History(ctx context.Context, mType, mName string, labels map[string]string, from, to time.Time, step time.Duration) ([]memstorage.Sample, error)
+	// Snapshot целиком записывает текущее состояние в постоянное хранилище
+	Snapshot(ctx context.Context) error
+	// Restore загружает состояние из постоянного хранилища
+	Restore(ctx context.Context) error
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+func validateMetric(metric *memstorage.Metrics) error {
+	switch metric.MType {
+	case "gauge":
+		if metric.Value == nil {
+			return ErrInvalidMetric
+		}
+	case "counter":
+		if metric.Delta == nil {
+			return ErrInvalidMetric
+		}
+	case "histogram":
+		if metric.Histogram == nil || metric.Histogram.Validate() != nil {
+			return ErrInvalidMetric
+		}
+	default:
+		return ErrInvalidMetric
+	}
+	return nil
+}
+
+type MemoryStorage struct {
+	mem *memstorage.MemStorage
+}
+
+func NewMemoryStorage(mem *memstorage.MemStorage) *MemoryStorage {
+	return &MemoryStorage{mem: mem}
+}
+
+func (s *MemoryStorage) Save(ctx context.Context, metric *memstorage.Metrics) (*memstorage.Metrics, error) {
+	if err := validateMetric(metric); err != nil {
+		return nil, err
+	}
+	status, res := s.mem.SaveMetric(metric)
+	if status != http.StatusOK {
+		return nil, ErrInvalidMetric
+	}
+	return res, nil
+}
+
+func (s *MemoryStorage) SaveBatch(ctx context.Context, metrics []memstorage.Metrics) ([]memstorage.Metrics, error) {
+	for i := range metrics {
+		if err := validateMetric(&metrics[i]); err != nil {
+			return nil, err
+		}
+	}
+	status, res := s.mem.SaveMetrics(&metrics)
+	if status != http.StatusOK {
+		return nil, ErrInvalidMetric
+	}
+	return *res, nil
+}
+
+func (s *MemoryStorage) Get(ctx context.Context, mType, mName string, labels map[string]string) (*memstorage.Metrics, error) {
+	if mType != "gauge" && mType != "counter" && mType != "histogram" {
+		return nil, ErrInvalidMetric
+	}
+	status, res := s.mem.GetLabeledMetrics(mType, mName, labels)
+	if status != http.StatusOK {
+		return nil, ErrNotFound
+	}
+	return res, nil
+}
+
+func (s *MemoryStorage) List(ctx context.Context) ([]memstorage.Metrics, error) {
+	counters := s.mem.GetCounters()
+	gauges := s.mem.GetGauges()
+	histograms := s.mem.GetHistograms()
+
+	res := make([]memstorage.Metrics, 0, len(counters)+len(gauges)+len(histograms))
+	for k, v := range counters {
+		val := v
+		name, labels := memstorage.ParseSeriesKey(k)
+		res = append(res, memstorage.Metrics{ID: name, MType: "counter", Delta: &val, Labels: labels})
+	}
+	for k, v := range gauges {
+		val := v
+		name, labels := memstorage.ParseSeriesKey(k)
+		res = append(res, memstorage.Metrics{ID: name, MType: "gauge", Value: &val, Labels: labels})
+	}
+	for k, v := range histograms {
+		name, labels := memstorage.ParseSeriesKey(k)
+		res = append(res, memstorage.Metrics{ID: name, MType: "histogram", Histogram: v, Labels: labels})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].MType != res[j].MType {
+			return res[i].MType < res[j].MType
+		}
+		if res[i].ID != res[j].ID {
+			return res[i].ID < res[j].ID
+		}
+		return memstorage.FormatLabels(res[i].Labels) < memstorage.FormatLabels(res[j].Labels)
+	})
+	return res, nil
+}
+
+func (s *MemoryStorage) History(ctx context.Context, mType, mName string, labels map[string]string, from, to time.Time, step time.Duration) ([]memstorage.Sample, error) {
+	if mType != "gauge" && mType != "counter" {
+		return nil, ErrInvalidMetric
+	}
+	if s.mem.History == nil {
+		return nil, ErrHistoryDisabled
+	}
+	res, ok := s.mem.History.Range(mType, memstorage.SeriesKey(mName, labels), from, to, step)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return res, nil
+}
+
+func (s *MemoryStorage) Snapshot(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemoryStorage) Restore(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemoryStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemoryStorage) Close() error {
+	return nil
+}
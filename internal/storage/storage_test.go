@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kishenkoilya/metricsalerts/internal/memstorage"
+	"github.com/kishenkoilya/metricsalerts/internal/psqlinteraction"
+)
+
+// backend создаёт хранилище поверх переданной памяти. Повторный вызов должен
+// давать хранилище, смотрящее в то же постоянное хранилище, что и первый.
+type backend func(t *testing.T, mem *memstorage.MemStorage) Storage
+
+func memoryBackend() backend {
+	return func(t *testing.T, mem *memstorage.MemStorage) Storage {
+		return NewMemoryStorage(mem)
+	}
+}
+
+func fileBackend(t *testing.T, syncWrite bool) backend {
+	path := filepath.Join(t.TempDir(), "metrics-db.json")
+	return func(t *testing.T, mem *memstorage.MemStorage) Storage {
+		s, err := NewFileStorage(mem, path, syncWrite)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+}
+
+func dbBackend(t *testing.T, syncWrite bool) backend {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	return func(t *testing.T, mem *memstorage.MemStorage) Storage {
+		s, err := NewDBStorage(mem, dsn, syncWrite, time.Hour, psqlinteraction.PoolConfig{MaxConns: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+}
+
+func TestStorageConformance(t *testing.T) {
+	tests := []struct {
+		name       string
+		newBackend func(t *testing.T) backend
+		persistent bool
+	}{
+		{name: "memory", newBackend: func(t *testing.T) backend { return memoryBackend() }},
+		{name: "file", newBackend: func(t *testing.T) backend { return fileBackend(t, false) }, persistent: true},
+		{name: "file sync", newBackend: func(t *testing.T) backend { return fileBackend(t, true) }, persistent: true},
+		{name: "postgres", newBackend: func(t *testing.T) backend { return dbBackend(t, false) }, persistent: true},
+		{name: "postgres sync", newBackend: func(t *testing.T) backend { return dbBackend(t, true) }, persistent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runConformance(t, tt.newBackend(t), tt.persistent)
+		})
+	}
+}
+
+func runConformance(t *testing.T, newStorage backend, persistent bool) {
+	ctx := context.Background()
+	// уникальный префикс, чтобы прогоны на общей базе не мешали друг другу
+	prefix := fmt.Sprintf("conf%d", time.Now().UnixNano())
+	mem := memstorage.NewMemStorage()
+	mem.History = memstorage.NewHistory(time.Hour, 100, 1<<20)
+	s := newStorage(t, mem)
+
+	if err := s.Ping(ctx); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+
+	gauge := 12.5
+	res, err := s.Save(ctx, &memstorage.Metrics{ID: prefix + "Gauge", MType: "gauge", Value: &gauge})
+	if err != nil || *res.Value != 12.5 {
+		t.Fatalf("Save(gauge) = %v, %v", res, err)
+	}
+	for i := 0; i < 2; i++ {
+		delta := int64(5)
+		res, err = s.Save(ctx, &memstorage.Metrics{ID: prefix + "Counter", MType: "counter", Delta: &delta})
+		if err != nil {
+			t.Fatalf("Save(counter) error = %v", err)
+		}
+	}
+	if *res.Delta != 10 {
+		t.Errorf("Save(counter) accumulated %d, want 10", *res.Delta)
+	}
+
+	if _, err = s.Save(ctx, &memstorage.Metrics{ID: prefix + "Bad", MType: "gauge"}); !errors.Is(err, ErrInvalidMetric) {
+		t.Errorf("Save(gauge without value) error = %v, want ErrInvalidMetric", err)
+	}
+	if _, err = s.Save(ctx, &memstorage.Metrics{ID: prefix + "Bad", MType: "summary", Value: &gauge}); !errors.Is(err, ErrInvalidMetric) {
+		t.Errorf("Save(unknown type) error = %v, want ErrInvalidMetric", err)
+	}
+
+	d1, d2, v1, v2 := int64(1), int64(2), 1.5, 2.5
+	batch, err := s.SaveBatch(ctx, []memstorage.Metrics{
+		{ID: prefix + "Counter", MType: "counter", Delta: &d1},
+		{ID: prefix + "Gauge", MType: "gauge", Value: &v1},
+		{ID: prefix + "Counter", MType: "counter", Delta: &d2},
+		{ID: prefix + "Gauge", MType: "gauge", Value: &v2},
+	})
+	if err != nil {
+		t.Fatalf("SaveBatch() error = %v", err)
+	}
+	if len(batch) != 2 {
+		t.Errorf("SaveBatch() returned %d metrics, want 2", len(batch))
+	}
+
+	got, err := s.Get(ctx, "counter", prefix+"Counter", nil)
+	if err != nil || *got.Delta != 13 {
+		t.Errorf("Get(counter) = %v, %v, want 13", got, err)
+	}
+	got, err = s.Get(ctx, "gauge", prefix+"Gauge", nil)
+	if err != nil || *got.Value != 2.5 {
+		t.Errorf("Get(gauge) = %v, %v, want 2.5", got, err)
+	}
+	if _, err = s.Get(ctx, "gauge", prefix+"Missing", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+
+	hostA, hostB := map[string]string{"host": "a"}, map[string]string{"host": "b"}
+	_, err = s.SaveBatch(ctx, []memstorage.Metrics{
+		{ID: prefix + "Alloc", MType: "gauge", Value: &v1, Labels: hostA},
+		{ID: prefix + "Alloc", MType: "gauge", Value: &v2, Labels: hostB},
+	})
+	if err != nil {
+		t.Fatalf("SaveBatch(labeled) error = %v", err)
+	}
+	got, err = s.Get(ctx, "gauge", prefix+"Alloc", hostA)
+	if err != nil || *got.Value != 1.5 || got.Labels["host"] != "a" {
+		t.Errorf("Get(labeled) = %v, %v, want 1.5", got, err)
+	}
+	if _, err = s.Get(ctx, "gauge", prefix+"Alloc", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(unlabeled) error = %v, want ErrNotFound", err)
+	}
+
+	history, err := s.History(ctx, "counter", prefix+"Counter", nil, time.Now().Add(-time.Minute), time.Now(), 0)
+	if err != nil || len(history) != 4 || history[3].Value != 13 {
+		t.Errorf("History(counter) = %v, %v", history, err)
+	}
+	if _, err = s.History(ctx, "gauge", prefix+"Missing", nil, time.Now().Add(-time.Minute), time.Now(), 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("History(missing) error = %v, want ErrNotFound", err)
+	}
+
+	list, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(list) != 4 || list[0].MType != "counter" || list[1].Labels["host"] != "a" || list[2].Labels["host"] != "b" {
+		t.Errorf("List() = %v", list)
+	}
+
+	hist := memstorage.NewHistogram([]float64{0.1, 1})
+	hist.Observe(0.05)
+	hist.Observe(5)
+	for i := 0; i < 2; i++ {
+		if _, err = s.Save(ctx, &memstorage.Metrics{ID: prefix + "Latency", MType: "histogram", Histogram: hist}); err != nil {
+			t.Fatalf("Save(histogram) error = %v", err)
+		}
+	}
+	got, err = s.Get(ctx, "histogram", prefix+"Latency", nil)
+	if err != nil || got.Histogram.Count != 4 || got.Histogram.Counts[2] != 2 {
+		t.Errorf("Get(histogram) = %v, %v, want 4 observations", got, err)
+	}
+	other := memstorage.NewHistogram([]float64{1, 2})
+	if _, err = s.Save(ctx, &memstorage.Metrics{ID: prefix + "Latency", MType: "histogram", Histogram: other}); !errors.Is(err, ErrInvalidMetric) {
+		t.Errorf("Save(histogram with other bounds) error = %v, want ErrInvalidMetric", err)
+	}
+	// пакет с несовместимой гистограммой отклоняется целиком
+	d3 := int64(100)
+	_, err = s.SaveBatch(ctx, []memstorage.Metrics{
+		{ID: prefix + "Counter", MType: "counter", Delta: &d3},
+		{ID: prefix + "Latency", MType: "histogram", Histogram: other},
+	})
+	if !errors.Is(err, ErrInvalidMetric) {
+		t.Errorf("SaveBatch(histogram with other bounds) error = %v, want ErrInvalidMetric", err)
+	}
+	got, err = s.Get(ctx, "counter", prefix+"Counter", nil)
+	if err != nil || *got.Delta != 13 {
+		t.Errorf("Get(counter) after rejected SaveBatch = %v, %v, want 13", got, err)
+	}
+
+	// одновременные пакеты не должны мешать друг другу
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			delta := int64(1)
+			_, err := s.SaveBatch(ctx, []memstorage.Metrics{{ID: prefix + "Concurrent", MType: "counter", Delta: &delta}})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent SaveBatch() error = %v", err)
+		}
+	}
+	got, err = s.Get(ctx, "counter", prefix+"Concurrent", nil)
+	if err != nil || *got.Delta != 8 {
+		t.Errorf("Get(concurrent counter) = %v, %v, want 8", got, err)
+	}
+
+	if err = s.Snapshot(ctx); err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+	if !persistent {
+		return
+	}
+
+	restored := memstorage.NewMemStorage()
+	s = newStorage(t, restored)
+	defer s.Close()
+	if err = s.Restore(ctx); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	got, err = s.Get(ctx, "counter", prefix+"Counter", nil)
+	if err != nil || *got.Delta != 13 {
+		t.Errorf("Get(counter) after Restore = %v, %v, want 13", got, err)
+	}
+	got, err = s.Get(ctx, "gauge", prefix+"Gauge", nil)
+	if err != nil || *got.Value != 2.5 {
+		t.Errorf("Get(gauge) after Restore = %v, %v, want 2.5", got, err)
+	}
+	got, err = s.Get(ctx, "gauge", prefix+"Alloc", hostB)
+	if err != nil || *got.Value != 2.5 {
+		t.Errorf("Get(labeled) after Restore = %v, %v, want 2.5", got, err)
+	}
+	got, err = s.Get(ctx, "histogram", prefix+"Latency", nil)
+	if err != nil || got.Histogram.Count != 4 || got.Histogram.Sum != 10.1 {
+		t.Errorf("Get(histogram) after Restore = %v, %v, want 4 observations", got, err)
+	}
+}
+
+func TestFileStorageWriteFailure(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics-db.json")
+	s, err := NewFileStorage(memstorage.NewMemStorage(), path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// закрытый файл - любая запись завершится ошибкой
+	s.syncWriter.Close()
+
+	for i := 0; i < 2; i++ {
+		delta := int64(5)
+		res, err := s.Save(ctx, &memstorage.Metrics{ID: "Counter", MType: "counter", Delta: &delta})
+		if err != nil {
+			t.Fatalf("Save() error = %v, want success once memory is updated", err)
+		}
+		if *res.Delta != int64(5*(i+1)) {
+			t.Errorf("Save() = %d, want %d", *res.Delta, 5*(i+1))
+		}
+	}
+	if !s.dirty {
+		t.Fatal("failed write must mark the file for rewrite")
+	}
+
+	s.writeMu.Lock()
+	err = s.rewrite()
+	s.writeMu.Unlock()
+	if err != nil {
+		t.Fatalf("rewrite() error = %v", err)
+	}
+	// после перезаписи снова работает дозапись
+	delta := int64(5)
+	if _, err = s.Save(ctx, &memstorage.Metrics{ID: "Counter", MType: "counter", Delta: &delta}); err != nil {
+		t.Fatalf("Save() after rewrite error = %v", err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+
+	restored, err := NewFileStorage(memstorage.NewMemStorage(), path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = restored.Restore(ctx); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	got, err := restored.Get(ctx, "counter", "Counter", nil)
+	if err != nil || *got.Delta != 15 {
+		t.Errorf("Get(counter) after Restore = %v, %v, want 15", got, err)
+	}
+}
@@ -0,0 +1,160 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// issue выпускает сертификат, подписанный parent; без parent сертификат самоподписанный CA.
+func issue(t *testing.T, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write сохраняет сертификат и ключ в PEM и возвращает пути к ним.
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "test CA", nil, 0)
+	otherCA := issue(t, "other CA", nil, 0)
+	caFile, _ := ca.write(t, dir, "ca")
+	serverCert, serverKey := issue(t, "server", ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+	agentCert, agentKey := issue(t, "agent", ca, x509.ExtKeyUsageClientAuth).write(t, dir, "agent")
+	strangerCert, strangerKey := issue(t, "stranger", otherCA, x509.ExtKeyUsageClientAuth).write(t, dir, "stranger")
+
+	serverConfig, err := Server(serverCert, serverKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.VerifiedChains) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = serverConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		caFile     string
+		certFile   string
+		keyFile    string
+		wantErr    bool
+		wantStatus int
+	}{
+		{name: "Test1", caFile: caFile, certFile: agentCert, keyFile: agentKey, wantStatus: http.StatusOK},
+		// без сертификата соединение устанавливается, но без проверенной цепочки
+		{name: "Test2", caFile: caFile, wantStatus: http.StatusForbidden},
+		// сертификат чужого CA клиент не предъявляет, и он тоже не проходит проверку
+		{name: "Test3", caFile: caFile, certFile: strangerCert, keyFile: strangerKey, wantStatus: http.StatusForbidden},
+		{name: "Test4", certFile: agentCert, keyFile: agentKey, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig, err := Client(tt.caFile, tt.certFile, tt.keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+			resp, err := client.Get(srv.URL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Get() status = %v, want %v", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestServerWithoutClientCA(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "test CA", nil, 0)
+	caFile, _ := ca.write(t, dir, "ca")
+	serverCert, serverKey := issue(t, "server", ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+
+	serverConfig, err := Server(serverCert, serverKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverConfig.ClientAuth != tls.NoClientCert {
+		t.Errorf("ClientAuth = %v, want NoClientCert", serverConfig.ClientAuth)
+	}
+	if _, err = Client(filepath.Join(dir, "missing.crt"), "", ""); err == nil {
+		t.Error("Client() with missing CA file should fail")
+	}
+	if _, err = Client(caFile, "", ""); err != nil {
+		t.Errorf("Client() error = %v", err)
+	}
+}